@@ -16,6 +16,9 @@ import (
 	"go.uber.org/zap"
 )
 
+// migrateHostRecordsCommand runs the migration to hostrecord sections instead of the webhook
+const migrateHostRecordsCommand = "migrate-hostrecords"
+
 func main() {
 	cfg := defaultConfig()
 	if err := config.Read(cfg); err != nil {
@@ -26,6 +29,11 @@ func main() {
 		panic(err)
 	}
 
+	if len(os.Args) > 1 && os.Args[1] == migrateHostRecordsCommand {
+		migrateHostRecords(cfg)
+		return
+	}
+
 	provider, err := provider.New(cfg.Provider)
 	if err != nil {
 		logger.Log.Fatal("failed to setup provider", zap.Error(err))
@@ -61,6 +69,19 @@ func main() {
 	logger.Log.Info("service shutdown completed")
 }
 
+// migrateHostRecords converts the owned domain sections of every router to hostrecord sections
+func migrateHostRecords(cfg *Config) {
+	count, skipped, err := provider.MigrateHostRecords(context.Background(), cfg.Provider)
+	if err != nil {
+		logger.Log.Fatal("failed to migrate host records", zap.Error(err))
+	}
+
+	if skipped > 0 {
+		logger.Log.Warn("invalid records were not migrated", zap.Int("skipped", skipped))
+	}
+	logger.Log.Info("migrated host records", zap.Int("count", count))
+}
+
 func setupRoutes(r *gin.Engine, webhook *webhook.Webhook) {
 	apiGroup := r.Group("/")
 	apiGroup.GET("/", webhook.Negotiate)
@@ -40,6 +40,36 @@ func (m *MockLuciRPC) EXPECT() *MockLuciRPCMockRecorder {
 	return m.recorder
 }
 
+// Fs mocks base method.
+func (m *MockLuciRPC) Fs(arg0 context.Context, arg1 string, arg2 []string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Fs", arg0, arg1, arg2)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Fs indicates an expected call of Fs.
+func (mr *MockLuciRPCMockRecorder) Fs(arg0, arg1, arg2 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Fs", reflect.TypeOf((*MockLuciRPC)(nil).Fs), arg0, arg1, arg2)
+}
+
+// Sys mocks base method.
+func (m *MockLuciRPC) Sys(arg0 context.Context, arg1 string, arg2 []string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Sys", arg0, arg1, arg2)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Sys indicates an expected call of Sys.
+func (mr *MockLuciRPCMockRecorder) Sys(arg0, arg1, arg2 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Sys", reflect.TypeOf((*MockLuciRPC)(nil).Sys), arg0, arg1, arg2)
+}
+
 // Uci mocks base method.
 func (m *MockLuciRPC) Uci(arg0 context.Context, arg1 string, arg2 []string) (string, error) {
 	m.ctrl.T.Helper()
@@ -54,3 +84,18 @@ func (mr *MockLuciRPCMockRecorder) Uci(arg0, arg1, arg2 any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Uci", reflect.TypeOf((*MockLuciRPC)(nil).Uci), arg0, arg1, arg2)
 }
+
+// UciList mocks base method.
+func (m *MockLuciRPC) UciList(arg0 context.Context, arg1 string, arg2, arg3 []string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UciList", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UciList indicates an expected call of UciList.
+func (mr *MockLuciRPCMockRecorder) UciList(arg0, arg1, arg2, arg3 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UciList", reflect.TypeOf((*MockLuciRPC)(nil).UciList), arg0, arg1, arg2, arg3)
+}
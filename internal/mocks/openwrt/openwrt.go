@@ -41,18 +41,46 @@ func (m *MockOpenWRT) EXPECT() *MockOpenWRTMockRecorder {
 	return m.recorder
 }
 
-// DeleteDNSRecords mocks base method.
-func (m *MockOpenWRT) DeleteDNSRecords(arg0 context.Context, arg1 []openwrt.DNSRecord) error {
+// AddDNSRecord mocks base method.
+func (m *MockOpenWRT) AddDNSRecord(arg0 context.Context, arg1 openwrt.DNSRecord) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "DeleteDNSRecords", arg0, arg1)
+	ret := m.ctrl.Call(m, "AddDNSRecord", arg0, arg1)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// DeleteDNSRecords indicates an expected call of DeleteDNSRecords.
-func (mr *MockOpenWRTMockRecorder) DeleteDNSRecords(arg0, arg1 any) *gomock.Call {
+// AddDNSRecord indicates an expected call of AddDNSRecord.
+func (mr *MockOpenWRTMockRecorder) AddDNSRecord(arg0, arg1 any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteDNSRecords", reflect.TypeOf((*MockOpenWRT)(nil).DeleteDNSRecords), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddDNSRecord", reflect.TypeOf((*MockOpenWRT)(nil).AddDNSRecord), arg0, arg1)
+}
+
+// Commit mocks base method.
+func (m *MockOpenWRT) Commit(arg0 context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Commit", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Commit indicates an expected call of Commit.
+func (mr *MockOpenWRTMockRecorder) Commit(arg0 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Commit", reflect.TypeOf((*MockOpenWRT)(nil).Commit), arg0)
+}
+
+// DeleteDNSRecord mocks base method.
+func (m *MockOpenWRT) DeleteDNSRecord(arg0 context.Context, arg1 openwrt.DNSRecord) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteDNSRecord", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteDNSRecord indicates an expected call of DeleteDNSRecord.
+func (mr *MockOpenWRTMockRecorder) DeleteDNSRecord(arg0, arg1 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteDNSRecord", reflect.TypeOf((*MockOpenWRT)(nil).DeleteDNSRecord), arg0, arg1)
 }
 
 // GetDNSRecords mocks base method.
@@ -70,30 +98,44 @@ func (mr *MockOpenWRTMockRecorder) GetDNSRecords(arg0 any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDNSRecords", reflect.TypeOf((*MockOpenWRT)(nil).GetDNSRecords), arg0)
 }
 
-// SetDNSRecords mocks base method.
-func (m *MockOpenWRT) SetDNSRecords(arg0 context.Context, arg1 []openwrt.DNSRecord) error {
+// Reload mocks base method.
+func (m *MockOpenWRT) Reload(arg0 context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Reload", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Reload indicates an expected call of Reload.
+func (mr *MockOpenWRTMockRecorder) Reload(arg0 any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Reload", reflect.TypeOf((*MockOpenWRT)(nil).Reload), arg0)
+}
+
+// Revert mocks base method.
+func (m *MockOpenWRT) Revert(arg0 context.Context) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "SetDNSRecords", arg0, arg1)
+	ret := m.ctrl.Call(m, "Revert", arg0)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// SetDNSRecords indicates an expected call of SetDNSRecords.
-func (mr *MockOpenWRTMockRecorder) SetDNSRecords(arg0, arg1 any) *gomock.Call {
+// Revert indicates an expected call of Revert.
+func (mr *MockOpenWRTMockRecorder) Revert(arg0 any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetDNSRecords", reflect.TypeOf((*MockOpenWRT)(nil).SetDNSRecords), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Revert", reflect.TypeOf((*MockOpenWRT)(nil).Revert), arg0)
 }
 
-// UpdateDNSRecords mocks base method.
-func (m *MockOpenWRT) UpdateDNSRecords(arg0 context.Context, arg1 []openwrt.DNSRecord) error {
+// UpdateDNSRecord mocks base method.
+func (m *MockOpenWRT) UpdateDNSRecord(ctx context.Context, current, desired openwrt.DNSRecord) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpdateDNSRecords", arg0, arg1)
+	ret := m.ctrl.Call(m, "UpdateDNSRecord", ctx, current, desired)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// UpdateDNSRecords indicates an expected call of UpdateDNSRecords.
-func (mr *MockOpenWRTMockRecorder) UpdateDNSRecords(arg0, arg1 any) *gomock.Call {
+// UpdateDNSRecord indicates an expected call of UpdateDNSRecord.
+func (mr *MockOpenWRTMockRecorder) UpdateDNSRecord(ctx, current, desired any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateDNSRecords", reflect.TypeOf((*MockOpenWRT)(nil).UpdateDNSRecords), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateDNSRecord", reflect.TypeOf((*MockOpenWRT)(nil).UpdateDNSRecord), ctx, current, desired)
 }
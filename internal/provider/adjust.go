@@ -0,0 +1,141 @@
+package provider
+
+import (
+	"math"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/renanqts/external-dns-openwrt-webhook/pkg/logger"
+	"github.com/renanqts/external-dns-openwrt-webhook/pkg/openwrt"
+	"go.uber.org/zap"
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+// AdjustEndpoints rewrites the desired endpoints the way the routers store them,
+// so the planner compares them with the records returned by Records.
+// Endpoints the routers of their domain cannot store are dropped.
+func (p *Provider) AdjustEndpoints(endpoints []*endpoint.Endpoint) ([]*endpoint.Endpoint, error) {
+	adjusted := make([]*endpoint.Endpoint, 0, len(endpoints))
+	for _, ep := range endpoints {
+		ep = ep.DeepCopy()
+		ep.DNSName = p.normalizeName(ep.DNSName)
+
+		switch ep.RecordType {
+		case endpoint.RecordTypeA, endpoint.RecordTypeAAAA:
+			// an address of the other family would fail the whole batch
+			ep.Targets = slices.DeleteFunc(ep.Targets, func(target string) bool {
+				ip, err := netip.ParseAddr(target)
+				if err != nil || ip.Is4() == (ep.RecordType == endpoint.RecordTypeA) {
+					return false
+				}
+				logger.Log.Warn("dropping target of another address family", zap.String("name", ep.DNSName),
+					zap.String("type", ep.RecordType), zap.String("target", target))
+				return true
+			})
+		case endpoint.RecordTypeCNAME:
+			for i, target := range ep.Targets {
+				ep.Targets[i] = p.normalizeName(target)
+			}
+		default:
+			logger.Log.Debug("dropping unsupported record type", zap.String("name", ep.DNSName), zap.String("type", ep.RecordType))
+			continue
+		}
+
+		if len(ep.Targets) == 0 {
+			logger.Log.Warn("dropping endpoint without targets", zap.String("name", ep.DNSName), zap.String("type", ep.RecordType))
+			continue
+		}
+
+		// a name has a single CNAME
+		if len(ep.Targets) > 1 && ep.RecordType == endpoint.RecordTypeCNAME {
+			logger.Log.Warn("keeping the first target only", zap.String("name", ep.DNSName), zap.String("type", ep.RecordType),
+				zap.Strings("targets", ep.Targets))
+			ep.Targets = ep.Targets[:1]
+		}
+
+		// a target listed twice is stored once
+		first := ep.Targets[0]
+		ep.Targets = slices.Compact(slices.Sorted(slices.Values(ep.Targets)))
+
+		if mac, ok := ep.GetProviderSpecificProperty(providerSpecificMAC); ok {
+			if hw, err := net.ParseMAC(mac); err == nil {
+				ep.SetProviderSpecificProperty(providerSpecificMAC, hw.String())
+			}
+		}
+
+		records, _ := endpoint2DNSRecords(ep)
+		record := records[0]
+		if !p.supports(record) && record.MAC != "" {
+			// the record is stored without its static lease
+			logger.Log.Warn("static lease not supported, dropping its mac", zap.String("name", ep.DNSName))
+			ep.DeleteProviderSpecificProperty(providerSpecificMAC)
+			record.MAC = ""
+		}
+
+		if !p.supports(record) {
+			logger.Log.Warn("dropping record not supported by the routers", zap.String("name", ep.DNSName),
+				zap.String("type", ep.RecordType))
+			continue
+		}
+
+		// a static lease has a single address
+		if len(ep.Targets) > 1 && record.MAC != "" {
+			logger.Log.Warn("keeping the first target of the static lease only", zap.String("name", ep.DNSName),
+				zap.Strings("targets", ep.Targets))
+			ep.Targets = endpoint.Targets{first}
+		}
+
+		ep.RecordTTL = normalizeTTL(ep.RecordTTL, record)
+		adjusted = append(adjusted, ep)
+	}
+
+	return adjusted, nil
+}
+
+// supports reports whether every router of the domain of the record can store it,
+// the domain is the one of the rewritten name
+func (p *Provider) supports(record openwrt.DNSRecord) bool {
+	name, err := p.rewriter.write(record.Key().Name)
+	if err != nil {
+		name = record.Key().Name
+	}
+
+	for _, r := range p.routers {
+		if r.domains.Match(name) && !openwrt.Supports(r.backend, record) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// normalizeName returns the name as Records returns it, internationalized names
+// are mapped through punycode. Invalid ones are left for ApplyChanges to reject.
+func (p *Provider) normalizeName(name string) string {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	ascii, err := toASCII(name)
+	if err != nil {
+		return name
+	}
+
+	if p.config.UnicodeNames {
+		return toUnicode(ascii)
+	}
+
+	return ascii
+}
+
+// normalizeTTL returns the ttl stored for the record,
+// records which cannot keep it get the default ttl
+func normalizeTTL(ttl endpoint.TTL, record openwrt.DNSRecord) endpoint.TTL {
+	switch {
+	case ttl < 0 || !record.KeepsTTL():
+		return 0
+	case ttl > math.MaxUint32:
+		return math.MaxUint32
+	default:
+		return ttl
+	}
+}
@@ -0,0 +1,131 @@
+package provider
+
+import (
+	"math"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/renanqts/external-dns-openwrt-webhook/pkg/openwrt"
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+var _ = Describe("Adjust endpoints", func() {
+	var p *Provider
+
+	BeforeEach(func() {
+		config := DefaultConfig()
+		p = &Provider{
+			config:  config,
+			routers: []*router{newRouter("primary", nil, config.Cache)},
+		}
+	})
+
+	adjust := func(endpoints ...*endpoint.Endpoint) []*endpoint.Endpoint {
+		adjusted, err := p.AdjustEndpoints(endpoints)
+		Expect(err).To(BeNil())
+		return adjusted
+	}
+
+	It("should normalize names", func() {
+		adjusted := adjust(
+			endpoint.NewEndpoint("A.Home.LAN.", endpoint.RecordTypeA, "192.168.1.10"),
+			endpoint.NewEndpoint("b.home.lan", endpoint.RecordTypeCNAME, "A.Home.LAN."),
+		)
+		Expect(adjusted).To(HaveLen(2))
+		Expect(adjusted[0].DNSName).To(Equal("a.home.lan"))
+		Expect(adjusted[1].Targets).To(Equal(endpoint.Targets{"a.home.lan"}))
+	})
+
+	It("should not change the endpoints passed", func() {
+		ep := endpoint.NewEndpoint("A.home.lan", endpoint.RecordTypeA, "192.168.1.10", "192.168.1.11")
+		adjust(ep)
+		Expect(ep.DNSName).To(Equal("A.home.lan"))
+		Expect(ep.Targets).To(HaveLen(2))
+	})
+
+	It("should drop unsupported records", func() {
+		adjusted := adjust(
+			endpoint.NewEndpoint("a.home.lan", endpoint.RecordTypeTXT, "heritage=external-dns"),
+			endpoint.NewEndpoint("a.home.lan", endpoint.RecordTypeMX, "10 mail.home.lan"),
+			endpoint.NewEndpoint("b.home.lan", endpoint.RecordTypeA),
+		)
+		Expect(adjusted).To(BeEmpty())
+	})
+
+	It("should keep every address", func() {
+		adjusted := adjust(endpoint.NewEndpoint("a.home.lan", endpoint.RecordTypeA, "192.168.1.11", "192.168.1.10", "192.168.1.11"))
+		Expect(adjusted).To(HaveLen(1))
+		Expect(adjusted[0].Targets).To(Equal(endpoint.Targets{"192.168.1.10", "192.168.1.11"}))
+	})
+
+	It("should keep the first target of cnames and static leases", func() {
+		adjusted := adjust(
+			endpoint.NewEndpoint("a.home.lan", endpoint.RecordTypeCNAME, "c.home.lan", "b.home.lan"),
+			endpoint.NewEndpoint("node.home.lan", endpoint.RecordTypeA, "192.168.1.11", "192.168.1.10").
+				WithProviderSpecific(providerSpecificMAC, "aa:bb:cc:dd:ee:ff"),
+		)
+		Expect(adjusted).To(HaveLen(2))
+		Expect(adjusted[0].Targets).To(Equal(endpoint.Targets{"c.home.lan"}))
+		Expect(adjusted[1].Targets).To(Equal(endpoint.Targets{"192.168.1.11"}))
+	})
+
+	It("should drop targets of the other address family", func() {
+		adjusted := adjust(
+			endpoint.NewEndpoint("a.home.lan", endpoint.RecordTypeA, "2001:db8::1", "192.168.1.10"),
+			endpoint.NewEndpoint("a.home.lan", endpoint.RecordTypeAAAA, "2001:db8::1", "192.168.1.10"),
+			endpoint.NewEndpoint("b.home.lan", endpoint.RecordTypeA, "2001:db8::2"),
+		)
+		Expect(adjusted).To(HaveLen(2))
+		Expect(adjusted[0].Targets).To(Equal(endpoint.Targets{"192.168.1.10"}))
+		Expect(adjusted[1].Targets).To(Equal(endpoint.Targets{"2001:db8::1"}))
+	})
+
+	It("should normalize ttls", func() {
+		adjusted := adjust(
+			endpoint.NewEndpointWithTTL("a.home.lan", endpoint.RecordTypeA, 600, "192.168.1.10"),
+			endpoint.NewEndpointWithTTL("b.home.lan", endpoint.RecordTypeA, -1, "192.168.1.10"),
+			endpoint.NewEndpointWithTTL("c.home.lan", endpoint.RecordTypeA, math.MaxUint32+1, "192.168.1.10"),
+			endpoint.NewEndpointWithTTL("*.apps.home.lan", endpoint.RecordTypeA, 600, "192.168.1.10"),
+		)
+		Expect(adjusted).To(HaveLen(4))
+		Expect(adjusted[0].RecordTTL).To(Equal(endpoint.TTL(600)))
+		Expect(adjusted[1].RecordTTL.IsConfigured()).To(BeFalse())
+		Expect(adjusted[2].RecordTTL).To(Equal(endpoint.TTL(math.MaxUint32)))
+		Expect(adjusted[3].RecordTTL.IsConfigured()).To(BeFalse())
+	})
+
+	It("should normalize macs", func() {
+		adjusted := adjust(endpoint.NewEndpoint("a.home.lan", endpoint.RecordTypeA, "192.168.1.10").
+			WithProviderSpecific(providerSpecificMAC, "AA-BB-CC-DD-EE-FF"))
+		Expect(adjusted).To(HaveLen(1))
+		mac, ok := adjusted[0].GetProviderSpecificProperty(providerSpecificMAC)
+		Expect(ok).To(BeTrue())
+		Expect(mac).To(Equal("aa:bb:cc:dd:ee:ff"))
+	})
+
+	Context("with routers of other backends", func() {
+		BeforeEach(func() {
+			hosts := newRouter("hosts", nil, p.config.Cache)
+			hosts.backend = openwrt.BackendHosts
+			hosts.domains = endpoint.NewDomainFilter([]string{"parents.lan"})
+			p.routers = append(p.routers, hosts)
+		})
+
+		It("should drop records the routers of their domain cannot store", func() {
+			adjusted := adjust(
+				endpoint.NewEndpoint("a.home.lan", endpoint.RecordTypeCNAME, "b.home.lan"),
+				endpoint.NewEndpoint("a.parents.lan", endpoint.RecordTypeCNAME, "b.parents.lan"),
+				endpoint.NewEndpoint("*.parents.lan", endpoint.RecordTypeA, "192.168.2.10"),
+			)
+			Expect(adjusted).To(HaveLen(1))
+			Expect(adjusted[0].DNSName).To(Equal("a.home.lan"))
+		})
+
+		It("should store static leases as plain records", func() {
+			adjusted := adjust(endpoint.NewEndpoint("a.parents.lan", endpoint.RecordTypeA, "192.168.2.10").
+				WithProviderSpecific(providerSpecificMAC, "aa:bb:cc:dd:ee:ff"))
+			Expect(adjusted).To(HaveLen(1))
+			Expect(adjusted[0].ProviderSpecific).To(BeEmpty())
+		})
+	})
+})
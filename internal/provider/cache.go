@@ -0,0 +1,145 @@
+package provider
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/renanqts/external-dns-openwrt-webhook/pkg/logger"
+	"github.com/renanqts/external-dns-openwrt-webhook/pkg/openwrt"
+	"go.uber.org/zap"
+)
+
+const (
+	cacheResultHit   = "hit"
+	cacheResultStale = "stale"
+	cacheResultMiss  = "miss"
+)
+
+// refresh is a fetch of the records from the router shared by concurrent lookups
+type refresh struct {
+	done    chan struct{}
+	records map[string]openwrt.DNSRecord
+	err     error
+}
+
+// recordCache keeps the records of the router for the configured TTL.
+// Expired records are refreshed in the background and served while the
+// router takes longer than the refresh timeout to answer.
+type recordCache struct {
+	config  *CacheConfig
+	openwrt openwrt.OpenWRT
+	now     func() time.Time
+
+	mu      sync.Mutex
+	records map[string]openwrt.DNSRecord
+	fetched time.Time
+	// generation discards refreshes started before the records were replaced
+	generation uint64
+	pending    *refresh
+}
+
+func newRecordCache(config *CacheConfig, opwrt openwrt.OpenWRT) *recordCache {
+	return &recordCache{
+		config:  config,
+		openwrt: opwrt,
+		now:     time.Now,
+	}
+}
+
+// get returns the cached records, they are fetched when there are none yet
+func (c *recordCache) get(ctx context.Context) (map[string]openwrt.DNSRecord, error) {
+	if c.config.TTL <= 0 {
+		return c.openwrt.GetDNSRecords(ctx)
+	}
+
+	c.mu.Lock()
+	records := c.records
+	if records != nil && c.now().Sub(c.fetched) < time.Duration(c.config.TTL)*time.Second {
+		c.mu.Unlock()
+		cacheLookups.WithLabelValues(cacheResultHit).Inc()
+		return records, nil
+	}
+	r := c.refresh(ctx)
+	c.mu.Unlock()
+
+	// without records to fall back to it waits for the router
+	var timeout <-chan time.Time
+	if records != nil {
+		timer := time.NewTimer(time.Duration(c.config.RefreshTimeout) * time.Second)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-r.done:
+	case <-timeout:
+		logger.Log.Debug("serving stale records while refreshing")
+		cacheLookups.WithLabelValues(cacheResultStale).Inc()
+		return records, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	if r.err != nil && records != nil {
+		logger.Log.Warn("failed to refresh records, serving stale ones", zap.Error(r.err))
+		cacheLookups.WithLabelValues(cacheResultStale).Inc()
+		return records, nil
+	}
+
+	cacheLookups.WithLabelValues(cacheResultMiss).Inc()
+	return r.records, r.err
+}
+
+// load fetches the records from the router bypassing the cache and keeps them
+func (c *recordCache) load(ctx context.Context) (map[string]openwrt.DNSRecord, error) {
+	records, err := c.openwrt.GetDNSRecords(ctx)
+	if err != nil || c.config.TTL <= 0 {
+		return records, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.records = records
+	c.fetched = c.now()
+
+	return records, nil
+}
+
+// expire marks the records as outdated after a write, they are served
+// until a refresh replaces them
+func (c *recordCache) expire() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	c.fetched = time.Time{}
+}
+
+// refresh starts a fetch unless one is already running, c.mu must be held
+func (c *recordCache) refresh(ctx context.Context) *refresh {
+	if c.pending != nil {
+		return c.pending
+	}
+
+	r := &refresh{done: make(chan struct{})}
+	c.pending = r
+	generation := c.generation
+
+	// the fetch outlives the lookup which started it
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		defer close(r.done)
+		r.records, r.err = c.openwrt.GetDNSRecords(ctx)
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.pending = nil
+		if r.err == nil && generation == c.generation {
+			c.records = r.records
+			c.fetched = c.now()
+		}
+	}()
+
+	return r
+}
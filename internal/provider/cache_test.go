@@ -0,0 +1,142 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	mocks "github.com/renanqts/external-dns-openwrt-webhook/internal/mocks/openwrt"
+	"github.com/renanqts/external-dns-openwrt-webhook/pkg/openwrt"
+	"go.uber.org/mock/gomock"
+)
+
+var _ = Describe("Cache", func() {
+	var (
+		ctx         context.Context
+		mockCtrl    *gomock.Controller
+		mockOpenWRT *mocks.MockOpenWRT
+		cache       *recordCache
+		now         time.Time
+
+		old     = map[string]openwrt.DNSRecord{"x": {Type: "A", Name: "a.foobar.com", IP: "1.1.1.1", Section: "x"}}
+		current = map[string]openwrt.DNSRecord{"x": {Type: "A", Name: "a.foobar.com", IP: "2.2.2.2", Section: "x"}}
+	)
+
+	lookups := func(result string) float64 {
+		return testutil.ToFloat64(cacheLookups.WithLabelValues(result))
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockOpenWRT = mocks.NewMockOpenWRT(mockCtrl)
+		cache = newRecordCache(&CacheConfig{TTL: 30, RefreshTimeout: 1}, mockOpenWRT)
+		now = time.Now()
+		cache.now = func() time.Time { return now }
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	It("should pass through when disabled", func() {
+		cache.config.TTL = 0
+		mockOpenWRT.EXPECT().GetDNSRecords(ctx).Return(current, nil).Times(2)
+
+		for range 2 {
+			records, err := cache.get(ctx)
+			Expect(err).To(BeNil())
+			Expect(records).To(Equal(current))
+		}
+	})
+
+	It("should serve records until they expire", func() {
+		hits, misses := lookups(cacheResultHit), lookups(cacheResultMiss)
+		mockOpenWRT.EXPECT().GetDNSRecords(gomock.Any()).Return(old, nil)
+
+		for range 2 {
+			records, err := cache.get(ctx)
+			Expect(err).To(BeNil())
+			Expect(records).To(Equal(old))
+		}
+		Expect(lookups(cacheResultMiss)).To(Equal(misses + 1))
+		Expect(lookups(cacheResultHit)).To(Equal(hits + 1))
+
+		now = now.Add(30 * time.Second)
+		mockOpenWRT.EXPECT().GetDNSRecords(gomock.Any()).Return(current, nil)
+		Expect(cache.get(ctx)).To(Equal(current))
+	})
+
+	It("should serve stale records while the router is slow", func() {
+		mockOpenWRT.EXPECT().GetDNSRecords(gomock.Any()).Return(old, nil)
+		Expect(cache.load(ctx)).Error().To(BeNil())
+		stale := lookups(cacheResultStale)
+		cache.config.RefreshTimeout = 0
+		now = now.Add(30 * time.Second)
+
+		release := make(chan struct{})
+		mockOpenWRT.EXPECT().GetDNSRecords(gomock.Any()).DoAndReturn(func(context.Context) (map[string]openwrt.DNSRecord, error) {
+			<-release
+			return current, nil
+		})
+
+		Expect(cache.get(ctx)).To(Equal(old))
+		Expect(cache.get(ctx)).To(Equal(old))
+		Expect(lookups(cacheResultStale)).To(Equal(stale + 2))
+
+		close(release)
+		Eventually(func() map[string]openwrt.DNSRecord {
+			records, _ := cache.get(ctx)
+			return records
+		}).Should(Equal(current))
+	})
+
+	It("should serve stale records when the refresh fails", func() {
+		mockOpenWRT.EXPECT().GetDNSRecords(gomock.Any()).Return(old, nil)
+		Expect(cache.load(ctx)).Error().To(BeNil())
+		now = now.Add(30 * time.Second)
+
+		mockOpenWRT.EXPECT().GetDNSRecords(gomock.Any()).Return(nil, errors.New("timeout"))
+		Expect(cache.get(ctx)).To(Equal(old))
+	})
+
+	It("should fail without records to serve", func() {
+		mockOpenWRT.EXPECT().GetDNSRecords(gomock.Any()).Return(nil, errors.New("timeout"))
+		_, err := cache.get(ctx)
+		Expect(err).To(MatchError("timeout"))
+	})
+
+	It("should refresh records after a write", func() {
+		mockOpenWRT.EXPECT().GetDNSRecords(gomock.Any()).Return(old, nil)
+		Expect(cache.load(ctx)).Error().To(BeNil())
+
+		cache.expire()
+		mockOpenWRT.EXPECT().GetDNSRecords(gomock.Any()).Return(current, nil)
+		Expect(cache.get(ctx)).To(Equal(current))
+	})
+
+	It("should discard refreshes started before a write", func() {
+		mockOpenWRT.EXPECT().GetDNSRecords(gomock.Any()).Return(old, nil)
+		Expect(cache.load(ctx)).Error().To(BeNil())
+		now = now.Add(30 * time.Second)
+
+		release := make(chan struct{})
+		mockOpenWRT.EXPECT().GetDNSRecords(gomock.Any()).DoAndReturn(func(context.Context) (map[string]openwrt.DNSRecord, error) {
+			<-release
+			return old, nil
+		})
+		cache.mu.Lock()
+		r := cache.refresh(ctx)
+		cache.mu.Unlock()
+
+		cache.expire()
+		close(release)
+		<-r.done
+
+		mockOpenWRT.EXPECT().GetDNSRecords(gomock.Any()).Return(current, nil)
+		Expect(cache.get(ctx)).To(Equal(current))
+	})
+})
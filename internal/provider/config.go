@@ -1,15 +1,254 @@
 package provider
 
 import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
 	"github.com/renanqts/external-dns-openwrt-webhook/pkg/openwrt"
+	"sigs.k8s.io/external-dns/endpoint"
+)
+
+// policies for updates and deletes of records which are not on the router
+const (
+	MissingRecordPolicyError            = "error"
+	MissingRecordPolicyWarn             = "warn"
+	MissingRecordPolicyIgnore           = "ignore"
+	MissingRecordPolicyRecreateOnUpdate = "recreate-on-update"
+)
+
+// policies for routers failing while the others succeed
+const (
+	FailurePolicyFail       = "fail"
+	FailurePolicyBestEffort = "best-effort"
+	FailurePolicyQuorum     = "quorum"
+)
+
+const (
+	defaultCacheRefreshTimeout = 2
+	defaultTTL                 = 300
+	defaultOwnerID             = "external-dns"
+	defaultMaxDeletions        = 10
+	defaultGracePeriod         = 300
+	defaultForgetAfter         = 600
 )
 
+// CacheConfig sets how long the records of the router are kept, in seconds.
+// Records are not cached when TTL is 0.
+type CacheConfig struct {
+	TTL int `mapstructure:"ttl"`
+	// RefreshTimeout is how long a lookup waits for expired records to be refreshed
+	// before it serves them anyway, it has to stay below the webhook read timeout
+	// of external-dns or the stale records arrive too late
+	RefreshTimeout int `mapstructure:"refresh_timeout"`
+}
+
+// SyncConfig guards the records against deletes of the sync policy
+type SyncConfig struct {
+	// OwnerID is stored in the records added by the webhook, only they are deleted.
+	// Any record is deleted when it is empty.
+	OwnerID string `mapstructure:"owner_id"`
+	// MaxDeletions holds every delete of a sync deleting more records, 0 disables the limit
+	MaxDeletions int `mapstructure:"max_deletions"`
+	// GracePeriod is how long a record is requested to be deleted before it is, in seconds
+	GracePeriod int `mapstructure:"grace_period"`
+	// ForgetAfter starts a delete request over when it is not repeated for as long, in seconds.
+	// It has to be longer than the sync interval of external-dns, 0 never forgets a request.
+	ForgetAfter int `mapstructure:"forget_after"`
+}
+
+// DomainFilterConfig restricts the domains managed by the webhook,
+// either with lists of domains or with regular expressions
+type DomainFilterConfig struct {
+	Include      []string `mapstructure:"include"`
+	Exclude      []string `mapstructure:"exclude"`
+	RegexInclude string   `mapstructure:"regex_include"`
+	RegexExclude string   `mapstructure:"regex_exclude"`
+}
+
+// filter returns the domain filter, it matches every domain when nothing is set
+func (c *DomainFilterConfig) filter() (endpoint.DomainFilter, error) {
+	if c.RegexInclude == "" && c.RegexExclude == "" {
+		return endpoint.NewDomainFilterWithExclusions(c.Include, c.Exclude), nil
+	}
+
+	if len(c.Include) > 0 || len(c.Exclude) > 0 {
+		return endpoint.DomainFilter{}, fmt.Errorf("invalid domain filter: domain lists and regular expressions cannot be combined")
+	}
+
+	var include, exclude *regexp.Regexp
+	var err error
+	if c.RegexInclude != "" {
+		if include, err = regexp.Compile(c.RegexInclude); err != nil {
+			return endpoint.DomainFilter{}, fmt.Errorf("invalid domain filter regex_include: %w", err)
+		}
+	}
+
+	if c.RegexExclude != "" {
+		if exclude, err = regexp.Compile(c.RegexExclude); err != nil {
+			return endpoint.DomainFilter{}, fmt.Errorf("invalid domain filter regex_exclude: %w", err)
+		}
+	}
+
+	return endpoint.NewRegexDomainFilter(include, exclude), nil
+}
+
+// RewriteRule renames the endpoints written to the routers, e.g. from the domain of the
+// cluster to the domain of the LAN, the records read from the routers are renamed back.
+// Either Suffix or Regex is set.
+type RewriteRule struct {
+	// Suffix is the domain replaced by Replacement, e.g. example.com by home.lan
+	Suffix string `mapstructure:"suffix"`
+	// Regex matches whole names, Replacement refers to its capture groups as ${1}
+	Regex       string `mapstructure:"regex"`
+	Replacement string `mapstructure:"replacement"`
+	// ReverseRegex and ReverseReplacement rename the records read back, required with Regex
+	ReverseRegex       string `mapstructure:"reverse_regex"`
+	ReverseReplacement string `mapstructure:"reverse_replacement"`
+}
+
+// TranslateRule translates the addresses of the A and AAAA records written to the routers,
+// e.g. public addresses to internal ones, the records read from the routers are translated back.
+// From and To are both addresses or both prefixes of the same length, e.g. 203.0.113.0/24 and
+// 192.168.10.0/24, the host bits are kept.
+type TranslateRule struct {
+	From string `mapstructure:"from"`
+	To   string `mapstructure:"to"`
+}
+
+// RouterConfig is a router receiving the changes of its domains,
+// or every change when it has none
+type RouterConfig struct {
+	Name    string   `mapstructure:"name"`
+	Domains []string `mapstructure:"domains"`
+	// OpenWRT overrides the openwrt settings of the provider for this router
+	OpenWRT map[string]any `mapstructure:"openwrt"`
+}
+
 type Config struct {
-	OpenWRT *openwrt.Config `mapstructure:"openwrt"`
+	OpenWRT             *openwrt.Config `mapstructure:"openwrt"`
+	MissingRecordPolicy string          `mapstructure:"missing_record_policy"`
+	// DefaultTTL is the ttl of records stored without one, in seconds
+	DefaultTTL   int                 `mapstructure:"default_ttl"`
+	DomainFilter *DomainFilterConfig `mapstructure:"domain_filter"`
+	Cache        *CacheConfig        `mapstructure:"cache"`
+	// Routers are written to instead of the router of OpenWRT when set
+	Routers       []RouterConfig `mapstructure:"routers"`
+	FailurePolicy string         `mapstructure:"failure_policy"`
+	// DryRun logs the writes of the changes and reverts them instead of committing
+	DryRun bool        `mapstructure:"dry_run"`
+	Sync   *SyncConfig `mapstructure:"sync"`
+	// UnicodeNames returns internationalized names in Unicode, in punycode as stored otherwise
+	UnicodeNames bool `mapstructure:"unicode_names"`
+	// Rewrite renames the endpoints with the first matching rule
+	Rewrite []RewriteRule `mapstructure:"rewrite"`
+	// Translate translates the targets of A and AAAA endpoints with the matching rule
+	Translate []TranslateRule `mapstructure:"translate"`
 }
 
 func DefaultConfig() *Config {
 	return &Config{
-		OpenWRT: openwrt.DefaultConfig(),
+		OpenWRT:             openwrt.DefaultConfig(),
+		MissingRecordPolicy: MissingRecordPolicyError,
+		DefaultTTL:          defaultTTL,
+		DomainFilter:        &DomainFilterConfig{},
+		FailurePolicy:       FailurePolicyFail,
+		Cache: &CacheConfig{
+			RefreshTimeout: defaultCacheRefreshTimeout,
+		},
+		Sync: &SyncConfig{
+			OwnerID:      defaultOwnerID,
+			MaxDeletions: defaultMaxDeletions,
+			GracePeriod:  defaultGracePeriod,
+			ForgetAfter:  defaultForgetAfter,
+		},
+		UnicodeNames: true,
+	}
+}
+
+// routerConfigs returns the openwrt config of every router by name
+func (c *Config) routerConfigs() ([]string, []*openwrt.Config, error) {
+	if len(c.Routers) == 0 {
+		return []string{c.OpenWRT.LuciRPC.Hostname}, []*openwrt.Config{c.OpenWRT}, nil
+	}
+
+	names := make([]string, 0, len(c.Routers))
+	configs := make([]*openwrt.Config, 0, len(c.Routers))
+	for _, router := range c.Routers {
+		config := c.OpenWRT.Clone()
+		decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+			ErrorUnused:      true,
+			WeaklyTypedInput: true,
+			Result:           config,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if err := decoder.Decode(router.OpenWRT); err != nil {
+			return nil, nil, fmt.Errorf("invalid router %s: %w", router.Name, err)
+		}
+
+		names = append(names, router.Name)
+		configs = append(configs, config)
+	}
+
+	return names, configs, nil
+}
+
+func (c *Config) validate() error {
+	switch c.MissingRecordPolicy {
+	case MissingRecordPolicyError, MissingRecordPolicyWarn, MissingRecordPolicyIgnore, MissingRecordPolicyRecreateOnUpdate:
+	default:
+		return fmt.Errorf("invalid missing record policy: %s", c.MissingRecordPolicy)
+	}
+
+	switch c.FailurePolicy {
+	case FailurePolicyFail, FailurePolicyBestEffort, FailurePolicyQuorum:
+	default:
+		return fmt.Errorf("invalid failure policy: %s", c.FailurePolicy)
+	}
+
+	if c.DefaultTTL <= 0 {
+		return fmt.Errorf("invalid default ttl: %d", c.DefaultTTL)
+	}
+
+	if _, err := c.DomainFilter.filter(); err != nil {
+		return err
+	}
+
+	if _, err := newNameRewriter(c.Rewrite); err != nil {
+		return err
+	}
+
+	if _, err := newAddressTranslator(c.Translate); err != nil {
+		return err
+	}
+
+	names := make(map[string]bool, len(c.Routers))
+	for _, router := range c.Routers {
+		if router.Name == "" {
+			return fmt.Errorf("router name is required")
+		}
+
+		if names[router.Name] {
+			return fmt.Errorf("duplicate router: %s", router.Name)
+		}
+		names[router.Name] = true
 	}
+
+	if c.Cache.TTL < 0 || c.Cache.RefreshTimeout < 0 {
+		return fmt.Errorf("invalid cache config: ttl and refresh timeout cannot be negative")
+	}
+
+	if strings.ContainsAny(c.Sync.OwnerID, " \t\n#=") {
+		return fmt.Errorf("invalid owner id: %q", c.Sync.OwnerID)
+	}
+
+	if c.Sync.MaxDeletions < 0 || c.Sync.GracePeriod < 0 || c.Sync.ForgetAfter < 0 {
+		return fmt.Errorf("invalid sync config: max deletions, grace period and forget after cannot be negative")
+	}
+
+	return nil
 }
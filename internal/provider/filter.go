@@ -0,0 +1,113 @@
+package provider
+
+import (
+	"slices"
+
+	"github.com/renanqts/external-dns-openwrt-webhook/pkg/logger"
+	"go.uber.org/zap"
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+// GetDomainFilter advertises the domain filter of the provider, its include list
+// defaults to the domains of the routers. Any domain is accepted when neither
+// is set. Domains renamed by the rewrite rules are advertised under both names.
+func (p *Provider) GetDomainFilter() endpoint.DomainFilterInterface {
+	config := p.config.DomainFilter
+	if config.RegexInclude != "" || config.RegexExclude != "" {
+		return p.domainFilter
+	}
+
+	include := config.Include
+	if len(include) == 0 {
+		include = p.routerDomains()
+	}
+
+	return endpoint.NewDomainFilterWithExclusions(p.readDomains(include), p.readDomains(config.Exclude))
+}
+
+// readDomains returns the domains along with the ones the rewrite rules rename them back to
+func (p *Provider) readDomains(domains []string) []string {
+	all := slices.Clone(domains)
+	for _, domain := range domains {
+		if read := p.rewriter.read(normalizeDomain(domain)); read != normalizeDomain(domain) && !slices.Contains(all, read) {
+			all = append(all, read)
+		}
+	}
+
+	return all
+}
+
+// routerDomains returns the domains of every router,
+// none when a router receives every change
+func (p *Provider) routerDomains() []string {
+	var domains []string
+	for _, r := range p.routers {
+		if !r.domains.IsConfigured() {
+			return nil
+		}
+		domains = append(domains, r.domains.Filters...)
+	}
+
+	return domains
+}
+
+// filter drops the changes outside of the domain filter,
+// external-dns only sends them when it is misconfigured
+func (p *Provider) filter(changes *plan.Changes) *plan.Changes {
+	if !p.domainFilter.IsConfigured() {
+		return changes
+	}
+
+	for _, ep := range slices.Concat(changes.Create, changes.UpdateNew, changes.Delete) {
+		if !p.domainFilter.Match(ep.DNSName) {
+			logger.Log.Warn("ignoring change outside of the domain filter", zap.String("name", ep.DNSName),
+				zap.String("type", ep.RecordType))
+		}
+	}
+
+	return filterChanges(changes, p.domainFilter)
+}
+
+// filterChanges returns the changes of the domains matched by filter. An update
+// renaming a record from or to another domain becomes a delete or a create.
+// UpdateOld and UpdateNew have to be paired, see checkUpdates.
+func filterChanges(changes *plan.Changes, filter endpoint.DomainFilter) *plan.Changes {
+	if !filter.IsConfigured() {
+		return changes
+	}
+
+	filtered := &plan.Changes{
+		Create: filterEndpoints(changes.Create, filter),
+		Delete: filterEndpoints(changes.Delete, filter),
+	}
+	for i, updateNew := range changes.UpdateNew {
+		updateOld := changes.UpdateOld[i]
+		switch oldMatch, newMatch := filter.Match(updateOld.DNSName), filter.Match(updateNew.DNSName); {
+		case oldMatch && newMatch:
+			filtered.UpdateOld = append(filtered.UpdateOld, updateOld)
+			filtered.UpdateNew = append(filtered.UpdateNew, updateNew)
+		case oldMatch:
+			filtered.Delete = append(filtered.Delete, updateOld)
+		case newMatch:
+			filtered.Create = append(filtered.Create, updateNew)
+		}
+	}
+
+	return filtered
+}
+
+func filterEndpoints(endpoints []*endpoint.Endpoint, filter endpoint.DomainFilter) []*endpoint.Endpoint {
+	if !filter.IsConfigured() {
+		return endpoints
+	}
+
+	var result []*endpoint.Endpoint
+	for _, ep := range endpoints {
+		if filter.Match(ep.DNSName) {
+			result = append(result, ep)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,131 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	mocks "github.com/renanqts/external-dns-openwrt-webhook/internal/mocks/openwrt"
+	"github.com/renanqts/external-dns-openwrt-webhook/pkg/openwrt"
+	"go.uber.org/mock/gomock"
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+var _ = Describe("Domain filter", func() {
+	var (
+		ctx         context.Context
+		mockCtrl    *gomock.Controller
+		mockOpenWRT *mocks.MockOpenWRT
+		p           *Provider
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockOpenWRT = mocks.NewMockOpenWRT(mockCtrl)
+
+		config := DefaultConfig()
+		config.DomainFilter = &DomainFilterConfig{Include: []string{"home.lan"}, Exclude: []string{"iot.home.lan"}}
+		domainFilter, err := config.DomainFilter.filter()
+		Expect(err).To(BeNil())
+		p = &Provider{
+			config:       config,
+			routers:      []*router{newRouter("primary", mockOpenWRT, config.Cache)},
+			domainFilter: domainFilter,
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	It("should advertise the domain lists", func() {
+		b, err := json.Marshal(p.GetDomainFilter())
+		Expect(err).To(BeNil())
+		Expect(string(b)).To(Equal(`{"include":["home.lan"],"exclude":["iot.home.lan"]}`))
+	})
+
+	It("should advertise the domains of the routers when nothing is included", func() {
+		p.config.DomainFilter.Include = nil
+		p.routers[0].domains = endpoint.NewDomainFilter([]string{"parents.lan"})
+
+		b, err := json.Marshal(p.GetDomainFilter())
+		Expect(err).To(BeNil())
+		Expect(string(b)).To(Equal(`{"include":["parents.lan"],"exclude":["iot.home.lan"]}`))
+	})
+
+	It("should advertise the regular expressions", func() {
+		p.config.DomainFilter = &DomainFilterConfig{RegexInclude: `\.home\.lan$`}
+		domainFilter, err := p.config.DomainFilter.filter()
+		Expect(err).To(BeNil())
+		p.domainFilter = domainFilter
+
+		b, err := json.Marshal(p.GetDomainFilter())
+		Expect(err).To(BeNil())
+		Expect(string(b)).To(Equal(`{"regexInclude":"\\.home\\.lan$"}`))
+	})
+
+	It("should only return records matching the filter", func() {
+		mockOpenWRT.EXPECT().GetDNSRecords(ctx).Return(map[string]openwrt.DNSRecord{
+			"x": {Type: "A", Name: "a.home.lan", IP: "192.168.1.10", Section: "x"},
+			"y": {Type: "A", Name: "cam.iot.home.lan", IP: "192.168.1.11", Section: "y"},
+			"z": {Type: "CNAME", CName: "b.other.lan", Target: "a.home.lan", Section: "z"},
+		}, nil)
+
+		endpoints, err := p.Records(ctx)
+		Expect(err).To(BeNil())
+		Expect(endpoints).To(HaveLen(1))
+		Expect(endpoints[0].DNSName).To(Equal("a.home.lan"))
+	})
+
+	It("should drop changes outside of the filter", func() {
+		gomock.InOrder(
+			mockOpenWRT.EXPECT().GetDNSRecords(ctx).Return(map[string]openwrt.DNSRecord{
+				"y": {Type: "A", Name: "cam.iot.home.lan", IP: "192.168.1.11", Section: "y"},
+			}, nil),
+			mockOpenWRT.EXPECT().AddDNSRecord(ctx, openwrt.DNSRecord{Type: "A", Name: "a.home.lan", IP: "192.168.1.10", Owner: "external-dns"}).Return(nil),
+			mockOpenWRT.EXPECT().Commit(ctx).Return(nil),
+			mockOpenWRT.EXPECT().Reload(ctx).Return(nil),
+		)
+
+		Expect(p.ApplyChanges(ctx, &plan.Changes{
+			Create: []*endpoint.Endpoint{
+				endpoint.NewEndpoint("a.home.lan", endpoint.RecordTypeA, "192.168.1.10"),
+				endpoint.NewEndpoint("b.other.lan", endpoint.RecordTypeA, "192.168.1.12"),
+			},
+			Delete: []*endpoint.Endpoint{endpoint.NewEndpoint("cam.iot.home.lan", endpoint.RecordTypeA, "192.168.1.11")},
+		})).To(Succeed())
+	})
+
+	It("should turn updates across the filter into deletes and creates", func() {
+		renamed := &plan.Changes{
+			UpdateOld: []*endpoint.Endpoint{
+				endpoint.NewEndpoint("a.home.lan", endpoint.RecordTypeA, "192.168.1.10"),
+				endpoint.NewEndpoint("b.other.lan", endpoint.RecordTypeA, "192.168.1.11"),
+				endpoint.NewEndpoint("c.home.lan", endpoint.RecordTypeA, "192.168.1.12"),
+			},
+			UpdateNew: []*endpoint.Endpoint{
+				endpoint.NewEndpoint("a.other.lan", endpoint.RecordTypeA, "192.168.1.10"),
+				endpoint.NewEndpoint("b.home.lan", endpoint.RecordTypeA, "192.168.1.11"),
+				endpoint.NewEndpoint("c.home.lan", endpoint.RecordTypeA, "192.168.1.13"),
+			},
+		}
+
+		filtered := filterChanges(renamed, p.domainFilter)
+		Expect(filtered.Delete).To(Equal([]*endpoint.Endpoint{renamed.UpdateOld[0]}))
+		Expect(filtered.Create).To(Equal([]*endpoint.Endpoint{renamed.UpdateNew[1]}))
+		Expect(filtered.UpdateOld).To(Equal([]*endpoint.Endpoint{renamed.UpdateOld[2]}))
+		Expect(filtered.UpdateNew).To(Equal([]*endpoint.Endpoint{renamed.UpdateNew[2]}))
+	})
+
+	It("should reject invalid filters", func() {
+		config := DefaultConfig()
+		config.DomainFilter = &DomainFilterConfig{Include: []string{"home.lan"}, RegexExclude: "iot"}
+		Expect(config.validate()).To(MatchError(ContainSubstring("cannot be combined")))
+
+		config.DomainFilter = &DomainFilterConfig{RegexInclude: "("}
+		Expect(config.validate()).To(MatchError(ContainSubstring("regex_include")))
+	})
+})
@@ -0,0 +1,80 @@
+package provider
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/renanqts/external-dns-openwrt-webhook/pkg/logger"
+	"go.uber.org/zap"
+	"golang.org/x/net/idna"
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+const (
+	wildcardPrefix = "*."
+	acePrefix      = "xn--"
+)
+
+// idnProfile maps and validates internationalized names the way resolvers look them up
+var idnProfile = idna.Lookup
+
+// toASCII returns the name with its internationalized labels in punycode,
+// ASCII names are returned as is
+func toASCII(name string) (string, error) {
+	if isASCII(name) {
+		return name, nil
+	}
+
+	ascii, err := idnProfile.ToASCII(strings.TrimPrefix(name, wildcardPrefix))
+	if err != nil {
+		return "", fmt.Errorf("invalid internationalized name %q: %w", name, err)
+	}
+
+	if strings.HasPrefix(name, wildcardPrefix) {
+		return wildcardPrefix + ascii, nil
+	}
+
+	return ascii, nil
+}
+
+// toUnicode returns the name with its punycode labels decoded,
+// names which are not valid punycode are returned as is
+func toUnicode(name string) string {
+	if !strings.Contains(name, acePrefix) {
+		return name
+	}
+
+	unicode, err := idnProfile.ToUnicode(strings.TrimPrefix(name, wildcardPrefix))
+	if err != nil {
+		logger.Log.Debug("keeping invalid punycode", zap.String("name", name), zap.Error(err))
+		return name
+	}
+
+	if strings.HasPrefix(name, wildcardPrefix) {
+		return wildcardPrefix + unicode
+	}
+
+	return unicode
+}
+
+// encodeChanges returns a copy of the changes with the names of the endpoints in punycode,
+// it fails on any invalid internationalized name
+func encodeChanges(changes *plan.Changes) (*plan.Changes, error) {
+	return renameChanges(changes, toASCII)
+}
+
+// decodeEndpoints decodes the punycode names and CNAME targets of the endpoints in place
+func decodeEndpoints(endpoints []*endpoint.Endpoint) {
+	renameEndpoints(endpoints, toUnicode)
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,103 @@
+package provider
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	mocks "github.com/renanqts/external-dns-openwrt-webhook/internal/mocks/openwrt"
+	"github.com/renanqts/external-dns-openwrt-webhook/pkg/openwrt"
+	"go.uber.org/mock/gomock"
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+var _ = Describe("Internationalized names", func() {
+	var (
+		ctx         context.Context
+		mockCtrl    *gomock.Controller
+		mockOpenWRT *mocks.MockOpenWRT
+		p           *Provider
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockOpenWRT = mocks.NewMockOpenWRT(mockCtrl)
+		config := DefaultConfig()
+		config.Sync.OwnerID = ""
+		p = &Provider{
+			config:  config,
+			routers: []*router{newRouter("primary", mockOpenWRT, config.Cache)},
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	It("should convert names to punycode and back", func() {
+		for name, ascii := range map[string]string{
+			"bücher.home.lan":   "xn--bcher-kva.home.lan",
+			"😀.home.lan":        "xn--e28h.home.lan",
+			"*.bücher.home.lan": "*.xn--bcher-kva.home.lan",
+			"a.home.lan":        "a.home.lan",
+		} {
+			encoded, err := toASCII(name)
+			Expect(err).To(BeNil())
+			Expect(encoded).To(Equal(ascii))
+			Expect(toUnicode(encoded)).To(Equal(name))
+		}
+	})
+
+	It("should reject invalid names", func() {
+		_, err := toASCII("-bücher.home.lan")
+		Expect(err).To(MatchError(ContainSubstring(`invalid internationalized name "-bücher.home.lan"`)))
+		Expect(toUnicode("xn--zz.home.lan")).To(Equal("xn--zz.home.lan"))
+	})
+
+	It("should write names in punycode", func() {
+		gomock.InOrder(
+			mockOpenWRT.EXPECT().GetDNSRecords(ctx).Return(map[string]openwrt.DNSRecord{}, nil),
+			mockOpenWRT.EXPECT().AddDNSRecord(ctx, openwrt.DNSRecord{Type: "CNAME", CName: "xn--e28h.home.lan", Target: "xn--bcher-kva.home.lan"}).Return(nil),
+			mockOpenWRT.EXPECT().Commit(ctx).Return(nil),
+			mockOpenWRT.EXPECT().Reload(ctx).Return(nil),
+		)
+
+		changes := &plan.Changes{Create: []*endpoint.Endpoint{endpoint.NewEndpoint("😀.home.lan", endpoint.RecordTypeCNAME, "bücher.home.lan")}}
+		Expect(p.ApplyChanges(ctx, changes)).To(Succeed())
+		Expect(changes.Create[0].DNSName).To(Equal("😀.home.lan"))
+	})
+
+	It("should reject changes with invalid names before reading the routers", func() {
+		Expect(p.ApplyChanges(ctx, &plan.Changes{
+			Create: []*endpoint.Endpoint{endpoint.NewEndpoint("-bücher.home.lan", endpoint.RecordTypeA, "1.1.1.1")},
+		})).To(MatchError(ContainSubstring("invalid internationalized name")))
+	})
+
+	It("should return names in unicode", func() {
+		mockOpenWRT.EXPECT().GetDNSRecords(ctx).Return(map[string]openwrt.DNSRecord{
+			"x": {Type: "A", Name: "xn--bcher-kva.home.lan", IP: "1.1.1.1", Section: "x"},
+		}, nil).Times(2)
+
+		endpoints, err := p.Records(ctx)
+		Expect(err).To(BeNil())
+		Expect(endpoints[0].DNSName).To(Equal("bücher.home.lan"))
+
+		p.config.UnicodeNames = false
+		endpoints, err = p.Records(ctx)
+		Expect(err).To(BeNil())
+		Expect(endpoints[0].DNSName).To(Equal("xn--bcher-kva.home.lan"))
+	})
+
+	It("should adjust names the way they are returned", func() {
+		adjusted, err := p.AdjustEndpoints([]*endpoint.Endpoint{endpoint.NewEndpoint("Bücher.home.lan.", endpoint.RecordTypeA, "1.1.1.1")})
+		Expect(err).To(BeNil())
+		Expect(adjusted[0].DNSName).To(Equal("bücher.home.lan"))
+
+		p.config.UnicodeNames = false
+		adjusted, err = p.AdjustEndpoints([]*endpoint.Endpoint{endpoint.NewEndpoint("Bücher.home.lan.", endpoint.RecordTypeA, "1.1.1.1")})
+		Expect(err).To(BeNil())
+		Expect(adjusted[0].DNSName).To(Equal("xn--bcher-kva.home.lan"))
+	})
+})
@@ -0,0 +1,77 @@
+package provider
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	metrics_namespace          = "external_dns_openwrt_webhook"
+	metrics_provider_subsystem = "provider"
+
+	routerResultSuccess = "success"
+	routerResultFailure = "failure"
+)
+
+var skippedRecords = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics_namespace,
+	Subsystem: metrics_provider_subsystem,
+	Name:      "skipped_records_total",
+	Help:      "Records skipped because they were not found on the router.",
+}, []string{"operation"})
+
+var cacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics_namespace,
+	Subsystem: metrics_provider_subsystem,
+	Name:      "cache_lookups_total",
+	Help:      "Record cache lookups by result: hit, stale or miss.",
+}, []string{"result"})
+
+var routerOperations = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics_namespace,
+	Subsystem: metrics_provider_subsystem,
+	Name:      "router_operations_total",
+	Help:      "Operations run against each router by result: success or failure.",
+}, []string{"router", "operation", "result"})
+
+var routerUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: metrics_namespace,
+	Subsystem: metrics_provider_subsystem,
+	Name:      "router_up",
+	Help:      "Whether the last operation against the router succeeded.",
+}, []string{"router"})
+
+var reloadFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics_namespace,
+	Subsystem: metrics_provider_subsystem,
+	Name:      "reload_failures_total",
+	Help:      "DNS server reloads failing after the changes were committed, retried on the next apply.",
+}, []string{"router"})
+
+var dryRunWrites = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics_namespace,
+	Subsystem: metrics_provider_subsystem,
+	Name:      "dry_run_writes_total",
+	Help:      "Writes staged on each router and reverted in dry run mode, by method.",
+}, []string{"router", "method"})
+
+var heldDeletions = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics_namespace,
+	Subsystem: metrics_provider_subsystem,
+	Name:      "held_deletions_total",
+	Help:      "Deletes held by reason: not_owned, grace_period or max_deletions.",
+}, []string{"reason"})
+
+var notOwnedChanges = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics_namespace,
+	Subsystem: metrics_provider_subsystem,
+	Name:      "not_owned_changes_total",
+	Help:      "Changes left out because the record is owned by someone else, by operation.",
+}, []string{"operation"})
+
+var inconsistentRecords = promauto.NewGauge(prometheus.GaugeOpts{
+	Namespace: metrics_namespace,
+	Subsystem: metrics_provider_subsystem,
+	Name:      "inconsistent_records",
+	Help:      "Records which are missing or different on some routers.",
+})
@@ -0,0 +1,46 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/renanqts/external-dns-openwrt-webhook/pkg/logger"
+	"github.com/renanqts/external-dns-openwrt-webhook/pkg/openwrt"
+	"go.uber.org/zap"
+)
+
+// MigrateHostRecords converts the domain sections owned by the webhook on every router to
+// hostrecord sections and returns the number of converted records and of invalid records
+// left as they are. Nothing is converted unless every router uses the dnsmasq backend,
+// routers are converted one after the other and a router converted already has nothing
+// left to convert when the migration runs again.
+func MigrateHostRecords(ctx context.Context, cfg *Config) (int, int, error) {
+	if err := cfg.validate(); err != nil {
+		return 0, 0, err
+	}
+
+	names, configs, err := cfg.routerConfigs()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for i, config := range configs {
+		if config.Backend != openwrt.BackendDnsmasq {
+			return 0, 0, fmt.Errorf("router %s: host records are not supported by the %s backend", names[i], config.Backend)
+		}
+	}
+
+	total, invalid := 0, 0
+	for i, config := range configs {
+		count, skipped, err := openwrt.MigrateHostRecords(ctx, config, cfg.Sync.OwnerID)
+		invalid += skipped
+		if err != nil {
+			return total, invalid, fmt.Errorf("%s: %w", names[i], err)
+		}
+		logger.Log.Info("migrated host records", zap.String("router", names[i]), zap.Int("count", count),
+			zap.Int("skipped", skipped))
+		total += count
+	}
+
+	return total, invalid, nil
+}
@@ -2,6 +2,11 @@ package provider
 
 import (
 	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/renanqts/external-dns-openwrt-webhook/pkg/logger"
 	"github.com/renanqts/external-dns-openwrt-webhook/pkg/openwrt"
@@ -11,80 +16,308 @@ import (
 	"sigs.k8s.io/external-dns/provider"
 )
 
-const defaultTTL = 300
+const (
+	// providerSpecificMAC turns an A record into a DHCP static lease,
+	// it is set by the external-dns.alpha.kubernetes.io/webhook-openwrt-mac annotation
+	providerSpecificMAC = "webhook/openwrt-mac"
+	// providerSpecificPartial marks the records missing or different on some routers,
+	// desired endpoints never have it so external-dns plans an update or a delete
+	providerSpecificPartial = "webhook/openwrt-partial"
+	// providerSpecificMisplaced marks the records stored in another dnsmasq instance than the
+	// one serving their name, desired endpoints never have it so external-dns plans an update
+	providerSpecificMisplaced = "webhook/openwrt-misplaced"
+)
 
 type Provider struct {
 	provider.BaseProvider
 
-	openwrt openwrt.OpenWRT
+	config  *Config
+	routers []*router
+	// domainFilter restricts the records read and written on every router
+	domainFilter endpoint.DomainFilter
+	deletes      *deleteGuard
+	rewriter     *nameRewriter
+	translator   *addressTranslator
 }
 
 func New(cfg *Config) (*Provider, error) {
-	opwrt, err := openwrt.New(cfg.OpenWRT)
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	names, configs, err := cfg.routerConfigs()
+	if err != nil {
+		return nil, err
+	}
+
+	domainFilter, err := cfg.DomainFilter.filter()
+	if err != nil {
+		return nil, err
+	}
+
+	rewriter, err := newNameRewriter(cfg.Rewrite)
+	if err != nil {
+		return nil, err
+	}
+
+	translator, err := newAddressTranslator(cfg.Translate)
 	if err != nil {
 		return nil, err
 	}
 
+	routers := make([]*router, 0, len(configs))
+	for i, config := range configs {
+		var observe openwrt.Observer
+		if cfg.DryRun {
+			observe = dryRunObserver(names[i])
+		}
+
+		opwrt, err := openwrt.New(config, observe)
+		if err != nil {
+			return nil, fmt.Errorf("router %s: %w", names[i], err)
+		}
+
+		r := newRouter(names[i], opwrt, cfg.Cache)
+		r.backend = config.Backend
+		if len(cfg.Routers) > 0 {
+			r.domains = endpoint.NewDomainFilter(cfg.Routers[i].Domains)
+		}
+		routers = append(routers, r)
+	}
+
 	return &Provider{
-		openwrt: opwrt,
+		config:       cfg,
+		routers:      routers,
+		domainFilter: domainFilter,
+		deletes:      newDeleteGuard(cfg.Sync),
+		rewriter:     rewriter,
+		translator:   translator,
 	}, nil
 }
 
+// ApplyChanges applies the changes of its domains to every router,
+// routers without any change are left out
 func (p *Provider) ApplyChanges(ctx context.Context, changes *plan.Changes) error {
 	logger.Log.Debug("apply changes", zap.Any("changes", changes))
-	_, err := p.openwrt.GetDNSRecords(ctx)
+	if err := checkUpdates(changes); err != nil {
+		return err
+	}
+
+	changes, err := encodeChanges(changes)
 	if err != nil {
 		return err
 	}
 
-	if err := p.openwrt.SetDNSRecords(ctx, endpoints2DNSRecords(changes.Create)); err != nil {
+	changes, err = renameChanges(changes, p.rewriter.write)
+	if err != nil {
 		return err
 	}
 
-	if err := p.openwrt.UpdateDNSRecords(ctx, endpoints2DNSRecords(changes.UpdateOld)); err != nil {
+	changes, err = translateChanges(changes, p.translator.write)
+	if err != nil {
 		return err
 	}
+	changes = p.filter(changes)
+	if err := validateChanges(changes); err != nil {
+		return err
+	}
+	changes = p.holdDeletes(changes)
+
+	routed := make(map[*router]*plan.Changes, len(p.routers))
+	var routers []*router
+	for _, r := range p.routers {
+		routed[r] = r.filter(changes)
+		if r.domains.IsConfigured() && !routed[r].HasChanges() {
+			logger.Log.Debug("no changes for router", zap.String("router", r.name))
+			continue
+		}
+		routers = append(routers, r)
+	}
+
+	return p.forEachRouter(ctx, "apply_changes", routers, func(ctx context.Context, r *router) error {
+		return p.apply(ctx, r, routed[r])
+	})
+}
 
-	if err := p.openwrt.UpdateDNSRecords(ctx, endpoints2DNSRecords(changes.UpdateNew)); err != nil {
+// apply reconciles the changes against a single snapshot of the router,
+// stages the resulting operations, commits them at once and reloads the DNS server.
+// On any failure before the commit the staged changes are reverted,
+// in dry run mode they are reverted instead of committed.
+func (p *Provider) apply(ctx context.Context, r *router, changes *plan.Changes) error {
+	// reconcile needs the current records, not the cached ones
+	records, err := r.cache.load(ctx)
+	if err != nil {
 		return err
 	}
 
-	if err := p.openwrt.DeleteDNSRecords(ctx, endpoints2DNSRecords(changes.Delete)); err != nil {
+	operations, err := reconcile(records, changes, p.config.MissingRecordPolicy, p.config.Sync.OwnerID)
+	if err != nil {
 		return err
 	}
 
+	if len(operations) == 0 {
+		logger.Log.Debug("nothing to apply", zap.String("router", r.name))
+		if r.reloadPending.Load() && !p.config.DryRun {
+			r.reload(ctx)
+		}
+		return nil
+	}
+
+	if err := validateCNAMEs(records, operations); err != nil {
+		return err
+	}
+
+	logger.Log.Info("applying operations", zap.String("router", r.name), zap.Stringers("operations", operations))
+	if err := r.execute(ctx, operations); err != nil {
+		r.revert(ctx)
+		return err
+	}
+
+	if p.config.DryRun {
+		logger.Log.Info("dry run, reverting operations", zap.String("router", r.name), zap.Int("operations", len(operations)))
+		return r.openwrt.Revert(ctx)
+	}
+
+	if err := r.openwrt.Commit(ctx); err != nil {
+		r.revert(ctx)
+		return err
+	}
+	r.cache.expire()
+	r.reload(ctx)
+
 	return nil
 }
 
+// dryRunObserver logs and counts the writes staged on the router
+func dryRunObserver(name string) openwrt.Observer {
+	return func(method string, params []string) {
+		logger.Log.Info("dry run write", zap.String("router", name), zap.String("write", method+" "+strings.Join(params, " ")))
+		dryRunWrites.WithLabelValues(name, method).Inc()
+	}
+}
+
+// reload makes the DNS server serve the committed records. The changes are committed
+// already, so a failure does not fail the apply: it is retried on the next one.
+func (r *router) reload(ctx context.Context) {
+	if err := r.openwrt.Reload(ctx); err != nil {
+		logger.Log.Error("failed to reload dns server, retrying on the next apply", zap.String("router", r.name), zap.Error(err))
+		reloadFailures.WithLabelValues(r.name).Inc()
+		r.reloadPending.Store(true)
+		return
+	}
+
+	r.reloadPending.Store(false)
+}
+
+func (r *router) revert(ctx context.Context) {
+	if err := r.openwrt.Revert(ctx); err != nil {
+		logger.Log.Error("failed to revert changes", zap.String("router", r.name), zap.Error(err))
+	}
+}
+
+// Records returns the records found on the routers of their domain, the ones missing or
+// different on some routers are marked as partial
 func (p *Provider) Records(ctx context.Context) ([]*endpoint.Endpoint, error) {
-	records, err := p.openwrt.GetDNSRecords(ctx)
+	snapshots := make([]map[string]openwrt.DNSRecord, len(p.routers))
+	fetched := make([]bool, len(p.routers))
+	err := p.forEachRouter(ctx, "records", p.routers, func(ctx context.Context, r *router) error {
+		records, err := r.cache.get(ctx)
+		if err != nil {
+			return err
+		}
+
+		i := slices.Index(p.routers, r)
+		snapshots[i], fetched[i] = records, true
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	return dnsRecords2Endpoints(records), nil
+	// routers tolerated to fail are left out
+	var routers []*router
+	var available []map[string]openwrt.DNSRecord
+	for i, snapshot := range snapshots {
+		if fetched[i] {
+			routers = append(routers, p.routers[i])
+			available = append(available, snapshot)
+		}
+	}
+
+	records, partial := consistentRecords(routers, available, p.config.Sync.OwnerID)
+	endpoints := dnsRecords2Endpoints(records, p.config.DefaultTTL)
+	for _, ep := range endpoints {
+		if partial[ep.RecordType+" "+ep.DNSName] {
+			ep.WithProviderSpecific(providerSpecificPartial, "true")
+		}
+	}
+	endpoints = filterEndpoints(endpoints, p.domainFilter)
+	renameEndpoints(endpoints, p.rewriter.read)
+	translateEndpoints(endpoints, p.translator.read)
+	if p.config.UnicodeNames {
+		decodeEndpoints(endpoints)
+	}
+
+	return endpoints, nil
 }
 
-func dnsRecords2Endpoints(dnsRecords map[string]openwrt.DNSRecord) []*endpoint.Endpoint {
+// dnsRecords2Endpoints returns an endpoint per name and type holding the targets of
+// its records, with the ttl of the first one. Records stored without a ttl get defaultTTL.
+func dnsRecords2Endpoints(dnsRecords map[string]openwrt.DNSRecord, defaultTTL int) []*endpoint.Endpoint {
 	var endpoints []*endpoint.Endpoint
 
-	for _, dnsRecord := range dnsRecords {
+	sets := openwrt.NewRecordSets(dnsRecords)
+	seen := make(map[openwrt.RecordKey]bool)
+	for _, key := range slices.Sorted(maps.Keys(dnsRecords)) {
+		recordKey := dnsRecords[key].Key()
+		if seen[recordKey] {
+			continue
+		}
+		seen[recordKey] = true
+
+		set := sets[recordKey]
+		dnsRecord := set[0]
 		var ep endpoint.Endpoint
 
 		switch dnsRecord.Type {
 		case "A":
 			ep.RecordType = endpoint.RecordTypeA
 			ep.DNSName = dnsRecord.Name
-			ep.Targets = endpoint.Targets{dnsRecord.IP}
+			if dnsRecord.MAC != "" {
+				ep.WithProviderSpecific(providerSpecificMAC, dnsRecord.MAC)
+			}
+		case "AAAA":
+			ep.RecordType = endpoint.RecordTypeAAAA
+			ep.DNSName = dnsRecord.Name
 		case "CNAME":
 			ep.RecordType = endpoint.RecordTypeCNAME
 			ep.DNSName = dnsRecord.CName
-			ep.Targets = endpoint.Targets{dnsRecord.Target}
 		default:
 			continue
 		}
 
-		ep.RecordTTL = defaultTTL
+		for _, record := range set {
+			ep.Targets = append(ep.Targets, record.Value())
+		}
+
+		if slices.ContainsFunc(set, func(record openwrt.DNSRecord) bool { return record.Misplaced }) {
+			ep.WithProviderSpecific(providerSpecificMisplaced, "true")
+		}
+
+		if len(dnsRecord.Labels) > 0 || dnsRecord.Owner != "" {
+			ep.Labels = maps.Clone(endpoint.Labels(dnsRecord.Labels))
+			if ep.Labels == nil {
+				ep.Labels = endpoint.Labels{}
+			}
+			if dnsRecord.Owner != "" {
+				ep.Labels[endpoint.OwnerLabelKey] = dnsRecord.Owner
+			}
+		}
+
+		ep.RecordTTL = endpoint.TTL(defaultTTL)
+		if ttl, err := strconv.ParseUint(dnsRecord.TTL, 10, 32); err == nil {
+			ep.RecordTTL = endpoint.TTL(ttl)
+		}
 		endpoints = append(endpoints, &ep)
 	}
 
@@ -95,22 +328,80 @@ func endpoints2DNSRecords(endpoints []*endpoint.Endpoint) []openwrt.DNSRecord {
 	var dnsRecords []openwrt.DNSRecord
 
 	for _, ep := range endpoints {
-		var dnsRecord openwrt.DNSRecord
-
-		switch ep.RecordType {
-		case endpoint.RecordTypeA:
-			dnsRecord.Type = "A"
-			dnsRecord.Name = ep.DNSName
-			dnsRecord.IP = ep.Targets[0]
-		case endpoint.RecordTypeCNAME:
-			dnsRecord.Type = "CNAME"
-			dnsRecord.CName = ep.DNSName
-			dnsRecord.Target = ep.Targets[0]
-		default:
-			continue
+		if records, ok := endpoint2DNSRecords(ep); ok {
+			dnsRecords = append(dnsRecords, records...)
 		}
-		dnsRecords = append(dnsRecords, dnsRecord)
 	}
 
 	return dnsRecords
 }
+
+// endpoint2DNSRecords converts the endpoint to a record per target,
+// it returns false for unsupported record types and endpoints without targets
+func endpoint2DNSRecords(ep *endpoint.Endpoint) ([]openwrt.DNSRecord, bool) {
+	var dnsRecord openwrt.DNSRecord
+
+	switch ep.RecordType {
+	case endpoint.RecordTypeA:
+		dnsRecord.Type = "A"
+		dnsRecord.Name = ep.DNSName
+		if mac, ok := ep.GetProviderSpecificProperty(providerSpecificMAC); ok {
+			dnsRecord.MAC = mac
+		}
+	case endpoint.RecordTypeAAAA:
+		dnsRecord.Type = "AAAA"
+		dnsRecord.Name = ep.DNSName
+	case endpoint.RecordTypeCNAME:
+		dnsRecord.Type = "CNAME"
+		dnsRecord.CName = ep.DNSName
+	default:
+		return nil, false
+	}
+
+	if len(ep.Targets) == 0 {
+		return nil, false
+	}
+
+	// endpoints without a ttl are stored without one and get the default ttl
+	if ep.RecordTTL.IsConfigured() {
+		dnsRecord.TTL = strconv.FormatInt(int64(ep.RecordTTL), 10)
+	}
+	dnsRecord.Labels = recordLabels(ep)
+
+	dnsRecords := make([]openwrt.DNSRecord, 0, len(ep.Targets))
+	for _, target := range ep.Targets {
+		record := dnsRecord
+		record.Labels = maps.Clone(dnsRecord.Labels)
+		if record.Type == "CNAME" {
+			record.Target = target
+		} else {
+			record.IP = target
+		}
+		dnsRecords = append(dnsRecords, record)
+	}
+
+	return dnsRecords, true
+}
+
+// recordLabels returns the labels stored along with the record of the endpoint,
+// the owner is stored on its own and labels the routers cannot store are dropped
+func recordLabels(ep *endpoint.Endpoint) map[string]string {
+	var labels map[string]string
+	for key, value := range ep.Labels {
+		if key == endpoint.OwnerLabelKey {
+			continue
+		}
+
+		if !openwrt.ValidLabel(key, value) {
+			logger.Log.Debug("dropping label", zap.String("name", ep.DNSName), zap.String("label", key+"="+value))
+			continue
+		}
+
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		labels[key] = value
+	}
+
+	return labels
+}
@@ -1,13 +1,20 @@
 package provider
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"testing"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	mocks "github.com/renanqts/external-dns-openwrt-webhook/internal/mocks/openwrt"
 	"github.com/renanqts/external-dns-openwrt-webhook/pkg/logger"
 	"github.com/renanqts/external-dns-openwrt-webhook/pkg/openwrt"
+	"go.uber.org/mock/gomock"
 	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
 )
 
 func TestProvider(t *testing.T) {
@@ -47,6 +54,11 @@ var _ = Describe("Provider Suite", func() {
 					Type:   "CNAME",
 					Target: "c.foobar.com",
 				},
+				{
+					Name:   "d.foobar.com",
+					Type:   "AAAA",
+					Target: "2001:db8::1",
+				},
 			}
 
 			var endpoints []*endpoint.Endpoint
@@ -59,9 +71,11 @@ var _ = Describe("Provider Suite", func() {
 				})
 			}
 			dnsRecords := endpoints2DNSRecords(endpoints)
+			Expect(dnsRecords).To(HaveLen(len(records)))
 			for index, dnsRecord := range dnsRecords {
+				Expect(dnsRecord.Type).To(Equal(records[index].Type))
 				switch dnsRecord.Type {
-				case "A":
+				case "A", "AAAA":
 					Expect(dnsRecord.Name).To(Equal(records[index].Name))
 					Expect(dnsRecord.IP).To(Equal(records[index].Target))
 				case "CNAME":
@@ -107,12 +121,549 @@ var _ = Describe("Provider Suite", func() {
 				}
 			}
 
-			endpoints := dnsRecords2Endpoints(dnsRecords)
+			endpoints := dnsRecords2Endpoints(dnsRecords, defaultTTL)
 			for index, record := range records {
 				Expect(endpoints[index].DNSName).To(Equal(record.Name))
 				Expect(endpoints[index].Targets[0]).To(Equal(record.Target))
 				Expect(endpoints[index].RecordType).To(Equal(record.Type))
 			}
 		})
+
+		It("should keep the mac of static leases", func() {
+			ep := endpoint.NewEndpointWithTTL("node.foobar.com", endpoint.RecordTypeA, defaultTTL, "192.168.1.10").
+				WithProviderSpecific(providerSpecificMAC, "aa:bb:cc:dd:ee:ff")
+
+			dnsRecords := endpoints2DNSRecords([]*endpoint.Endpoint{ep})
+			Expect(dnsRecords).To(HaveLen(1))
+			Expect(dnsRecords[0].MAC).To(Equal("aa:bb:cc:dd:ee:ff"))
+
+			endpoints := dnsRecords2Endpoints(map[string]openwrt.DNSRecord{"x": dnsRecords[0]}, defaultTTL)
+			Expect(endpoints).To(HaveLen(1))
+			Expect(endpoints[0].DNSName).To(Equal(ep.DNSName))
+			Expect(endpoints[0].Targets).To(Equal(ep.Targets))
+			Expect(endpoints[0].ProviderSpecific).To(Equal(ep.ProviderSpecific))
+		})
+
+		It("should keep the ttl of records", func() {
+			dnsRecords := endpoints2DNSRecords([]*endpoint.Endpoint{
+				endpoint.NewEndpointWithTTL("a.foobar.com", endpoint.RecordTypeA, 600, "1.1.1.1"),
+				endpoint.NewEndpoint("b.foobar.com", endpoint.RecordTypeCNAME, "a.foobar.com"),
+			})
+			Expect(dnsRecords).To(HaveLen(2))
+			Expect(dnsRecords[0].TTL).To(Equal("600"))
+			Expect(dnsRecords[1].TTL).To(BeEmpty())
+
+			endpoints := dnsRecords2Endpoints(map[string]openwrt.DNSRecord{"x": dnsRecords[0], "y": dnsRecords[1]}, 120)
+			Expect(endpoints).To(HaveLen(2))
+			Expect(endpoints[0].RecordTTL).To(Equal(endpoint.TTL(600)))
+			Expect(endpoints[1].RecordTTL).To(Equal(endpoint.TTL(120)))
+		})
+
+		It("should keep the labels of records", func() {
+			ep := endpoint.NewEndpoint("a.foobar.com", endpoint.RecordTypeA, "1.1.1.1")
+			ep.Labels = endpoint.Labels{
+				endpoint.OwnerLabelKey:    "cluster",
+				endpoint.ResourceLabelKey: "ingress/default/a",
+				"note":                    "two words",
+			}
+
+			dnsRecords := endpoints2DNSRecords([]*endpoint.Endpoint{ep})
+			Expect(dnsRecords).To(HaveLen(1))
+			Expect(dnsRecords[0].Labels).To(Equal(map[string]string{endpoint.ResourceLabelKey: "ingress/default/a"}))
+
+			dnsRecords[0].Owner = "external-dns"
+			endpoints := dnsRecords2Endpoints(map[string]openwrt.DNSRecord{"x": dnsRecords[0]}, defaultTTL)
+			Expect(endpoints).To(HaveLen(1))
+			Expect(endpoints[0].Labels).To(Equal(endpoint.Labels{
+				endpoint.OwnerLabelKey:    "external-dns",
+				endpoint.ResourceLabelKey: "ingress/default/a",
+			}))
+		})
+	})
+
+	Context("misplaced records", func() {
+		It("should be updated into their instance", func() {
+			records := map[string]openwrt.DNSRecord{
+				"x": {Type: "A", Name: "a.foobar.com", IP: "1.1.1.1", Section: "x", Misplaced: true},
+			}
+			endpoints := dnsRecords2Endpoints(records, defaultTTL)
+			Expect(endpoints).To(HaveLen(1))
+			misplaced, ok := endpoints[0].GetProviderSpecificProperty(providerSpecificMisplaced)
+			Expect(ok).To(BeTrue())
+			Expect(misplaced).To(Equal("true"))
+
+			desired := endpoint.NewEndpoint("a.foobar.com", endpoint.RecordTypeA, "1.1.1.1")
+			operations, err := reconcile(records, &plan.Changes{
+				UpdateOld: []*endpoint.Endpoint{endpoints[0]},
+				UpdateNew: []*endpoint.Endpoint{desired},
+			}, MissingRecordPolicyError, "")
+			Expect(err).To(BeNil())
+			Expect(operations).To(Equal([]operation{
+				{Type: operationUpdate, Current: records["x"], Desired: openwrt.DNSRecord{Type: "A", Name: "a.foobar.com", IP: "1.1.1.1"}},
+			}))
+		})
+	})
+
+	Context("reconcile", func() {
+		records := map[string]openwrt.DNSRecord{
+			"x": {Type: "A", Name: "a.foobar.com", IP: "1.1.1.1", Section: "x"},
+			"y": {Type: "A", Name: "b.foobar.com", IP: "1.1.1.1", Section: "y"},
+			"z": {Type: "CNAME", CName: "c.foobar.com", Target: "a.foobar.com", Section: "z"},
+		}
+
+		It("should order deletes, updates and creates", func() {
+			operations, err := reconcile(records, &plan.Changes{
+				Create:    []*endpoint.Endpoint{endpoint.NewEndpoint("d.foobar.com", endpoint.RecordTypeA, "4.4.4.4")},
+				UpdateOld: []*endpoint.Endpoint{endpoint.NewEndpoint("b.foobar.com", endpoint.RecordTypeA, "1.1.1.1")},
+				UpdateNew: []*endpoint.Endpoint{endpoint.NewEndpoint("b.foobar.com", endpoint.RecordTypeA, "2.2.2.2")},
+				Delete:    []*endpoint.Endpoint{endpoint.NewEndpoint("c.foobar.com", endpoint.RecordTypeCNAME, "a.foobar.com")},
+			}, MissingRecordPolicyError, "")
+			Expect(err).To(BeNil())
+			Expect(operations).To(Equal([]operation{
+				{Type: operationDelete, Current: records["z"]},
+				{Type: operationUpdate, Current: records["y"], Desired: openwrt.DNSRecord{Type: "A", Name: "b.foobar.com", IP: "2.2.2.2"}},
+				{Type: operationCreate, Desired: openwrt.DNSRecord{Type: "A", Name: "d.foobar.com", IP: "4.4.4.4"}},
+			}))
+			Expect(fmt.Sprint(operations)).To(Equal("[delete CNAME c.foobar.com a.foobar.com update A b.foobar.com 1.1.1.1 -> 2.2.2.2 create A d.foobar.com 4.4.4.4]"))
+		})
+
+		It("should skip records in the desired state", func() {
+			operations, err := reconcile(records, &plan.Changes{
+				Create:    []*endpoint.Endpoint{endpoint.NewEndpoint("a.foobar.com", endpoint.RecordTypeA, "1.1.1.1")},
+				UpdateOld: []*endpoint.Endpoint{endpoint.NewEndpoint("b.foobar.com", endpoint.RecordTypeA, "1.1.1.1")},
+				UpdateNew: []*endpoint.Endpoint{endpoint.NewEndpoint("b.foobar.com", endpoint.RecordTypeA, "1.1.1.1")},
+			}, MissingRecordPolicyError, "")
+			Expect(err).To(BeNil())
+			Expect(operations).To(BeEmpty())
+		})
+
+		It("should update records whose ttl changed", func() {
+			operations, err := reconcile(records, &plan.Changes{
+				UpdateOld: []*endpoint.Endpoint{endpoint.NewEndpoint("b.foobar.com", endpoint.RecordTypeA, "1.1.1.1")},
+				UpdateNew: []*endpoint.Endpoint{endpoint.NewEndpointWithTTL("b.foobar.com", endpoint.RecordTypeA, 600, "1.1.1.1")},
+			}, MissingRecordPolicyError, "")
+			Expect(err).To(BeNil())
+			Expect(operations).To(Equal([]operation{
+				{Type: operationUpdate, Current: records["y"], Desired: openwrt.DNSRecord{Type: "A", Name: "b.foobar.com", IP: "1.1.1.1", TTL: "600"}},
+			}))
+		})
+
+		It("should replace renamed records", func() {
+			operations, err := reconcile(records, &plan.Changes{
+				UpdateOld: []*endpoint.Endpoint{endpoint.NewEndpoint("a.foobar.com", endpoint.RecordTypeA, "1.1.1.1")},
+				UpdateNew: []*endpoint.Endpoint{endpoint.NewEndpoint("e.foobar.com", endpoint.RecordTypeA, "1.1.1.1")},
+			}, MissingRecordPolicyError, "")
+			Expect(err).To(BeNil())
+			Expect(operations).To(Equal([]operation{
+				{Type: operationDelete, Current: records["x"]},
+				{Type: operationCreate, Desired: openwrt.DNSRecord{Type: "A", Name: "e.foobar.com", IP: "1.1.1.1"}},
+			}))
+		})
+
+		It("should replace records changing type", func() {
+			operations, err := reconcile(records, &plan.Changes{
+				UpdateOld: []*endpoint.Endpoint{endpoint.NewEndpoint("b.foobar.com", endpoint.RecordTypeA, "1.1.1.1")},
+				UpdateNew: []*endpoint.Endpoint{endpoint.NewEndpoint("b.foobar.com", endpoint.RecordTypeCNAME, "a.foobar.com")},
+			}, MissingRecordPolicyError, "")
+			Expect(err).To(BeNil())
+			Expect(operations).To(Equal([]operation{
+				{Type: operationDelete, Current: records["y"]},
+				{Type: operationCreate, Desired: openwrt.DNSRecord{Type: "CNAME", CName: "b.foobar.com", Target: "a.foobar.com"}},
+			}))
+		})
+
+		It("should fail when the old record changed on the router", func() {
+			_, err := reconcile(records, &plan.Changes{
+				UpdateOld: []*endpoint.Endpoint{endpoint.NewEndpoint("b.foobar.com", endpoint.RecordTypeA, "9.9.9.9")},
+				UpdateNew: []*endpoint.Endpoint{endpoint.NewEndpoint("b.foobar.com", endpoint.RecordTypeA, "2.2.2.2")},
+			}, MissingRecordPolicyError, "")
+			Expect(err).To(MatchError("record A b.foobar.com changed on the router: 1.1.1.1, expected 9.9.9.9"))
+		})
+
+		It("should fail on unpaired updates", func() {
+			_, err := reconcile(records, &plan.Changes{
+				UpdateNew: []*endpoint.Endpoint{endpoint.NewEndpoint("b.foobar.com", endpoint.RecordTypeA, "2.2.2.2")},
+			}, MissingRecordPolicyError, "")
+			Expect(err).To(MatchError("mismatched updates: 0 old and 1 new endpoints"))
+		})
+
+		It("should delete conflicting records before creating", func() {
+			operations, err := reconcile(records, &plan.Changes{
+				Create: []*endpoint.Endpoint{endpoint.NewEndpoint("a.foobar.com", endpoint.RecordTypeA, "3.3.3.3")},
+			}, MissingRecordPolicyError, "")
+			Expect(err).To(BeNil())
+			Expect(operations).To(Equal([]operation{
+				{Type: operationDelete, Current: records["x"]},
+				{Type: operationCreate, Desired: openwrt.DNSRecord{Type: "A", Name: "a.foobar.com", IP: "3.3.3.3"}},
+			}))
+		})
+
+		It("should fail on records not found", func() {
+			_, err := reconcile(records, &plan.Changes{
+				Delete: []*endpoint.Endpoint{endpoint.NewEndpoint("whatever.foobar.com", endpoint.RecordTypeCNAME, "3.3.3.3")},
+			}, MissingRecordPolicyError, "")
+			Expect(err).ToNot(BeNil())
+			Expect(err.Error()).To(Equal("records not found: CNAME whatever.foobar.com 3.3.3.3"))
+		})
+
+		It("should skip missing records", func() {
+			skipped := testutil.ToFloat64(skippedRecords.WithLabelValues(string(operationDelete)))
+			operations, err := reconcile(records, &plan.Changes{
+				UpdateOld: []*endpoint.Endpoint{endpoint.NewEndpoint("whatever.foobar.com", endpoint.RecordTypeA, "2.2.2.2")},
+				UpdateNew: []*endpoint.Endpoint{endpoint.NewEndpoint("whatever.foobar.com", endpoint.RecordTypeA, "3.3.3.3")},
+				Delete: []*endpoint.Endpoint{
+					endpoint.NewEndpoint("c.foobar.com", endpoint.RecordTypeCNAME, "a.foobar.com"),
+					endpoint.NewEndpoint("whatever.foobar.com", endpoint.RecordTypeCNAME, "3.3.3.3"),
+				},
+			}, MissingRecordPolicyWarn, "")
+			Expect(err).To(BeNil())
+			Expect(operations).To(Equal([]operation{
+				{Type: operationDelete, Current: records["z"]},
+			}))
+			Expect(testutil.ToFloat64(skippedRecords.WithLabelValues(string(operationDelete)))).To(Equal(skipped + 1))
+		})
+
+		It("should recreate missing records on update", func() {
+			operations, err := reconcile(records, &plan.Changes{
+				UpdateOld: []*endpoint.Endpoint{endpoint.NewEndpoint("whatever.foobar.com", endpoint.RecordTypeA, "2.2.2.2")},
+				UpdateNew: []*endpoint.Endpoint{endpoint.NewEndpoint("whatever.foobar.com", endpoint.RecordTypeA, "3.3.3.3")},
+				Delete:    []*endpoint.Endpoint{endpoint.NewEndpoint("whatever.foobar.com", endpoint.RecordTypeCNAME, "3.3.3.3")},
+			}, MissingRecordPolicyRecreateOnUpdate, "")
+			Expect(err).To(BeNil())
+			Expect(operations).To(Equal([]operation{
+				{Type: operationCreate, Desired: openwrt.DNSRecord{Type: "A", Name: "whatever.foobar.com", IP: "3.3.3.3"}},
+			}))
+		})
+
+		It("should fail on conflicting changes", func() {
+			_, err := reconcile(records, &plan.Changes{
+				Create: []*endpoint.Endpoint{
+					endpoint.NewEndpoint("d.foobar.com", endpoint.RecordTypeA, "3.3.3.3"),
+					endpoint.NewEndpoint("d.foobar.com", endpoint.RecordTypeA, "4.4.4.4"),
+				},
+			}, MissingRecordPolicyError, "")
+			Expect(err).ToNot(BeNil())
+			Expect(err.Error()).To(Equal("conflicting changes for A d.foobar.com 4.4.4.4"))
+		})
+
+		Context("with several targets", func() {
+			multi := map[string]openwrt.DNSRecord{
+				"x": {Type: "A", Name: "a.foobar.com", IP: "1.1.1.1", Section: "x"},
+				"y": {Type: "A", Name: "a.foobar.com", IP: "2.2.2.2", Section: "y"},
+			}
+
+			It("should add a record per target", func() {
+				operations, err := reconcile(multi, &plan.Changes{
+					Create: []*endpoint.Endpoint{endpoint.NewEndpoint("b.foobar.com", endpoint.RecordTypeA, "3.3.3.3", "4.4.4.4")},
+				}, MissingRecordPolicyError, "")
+				Expect(err).To(BeNil())
+				Expect(operations).To(Equal([]operation{
+					{Type: operationCreate, Desired: openwrt.DNSRecord{Type: "A", Name: "b.foobar.com", IP: "3.3.3.3"}},
+					{Type: operationCreate, Desired: openwrt.DNSRecord{Type: "A", Name: "b.foobar.com", IP: "4.4.4.4"}},
+				}))
+			})
+
+			It("should only change the targets which differ", func() {
+				operations, err := reconcile(multi, &plan.Changes{
+					UpdateOld: []*endpoint.Endpoint{endpoint.NewEndpoint("a.foobar.com", endpoint.RecordTypeA, "2.2.2.2", "1.1.1.1")},
+					UpdateNew: []*endpoint.Endpoint{endpoint.NewEndpoint("a.foobar.com", endpoint.RecordTypeA, "2.2.2.2", "3.3.3.3", "4.4.4.4")},
+				}, MissingRecordPolicyError, "")
+				Expect(err).To(BeNil())
+				Expect(operations).To(Equal([]operation{
+					{Type: operationUpdate, Current: multi["x"], Desired: openwrt.DNSRecord{Type: "A", Name: "a.foobar.com", IP: "3.3.3.3"}},
+					{Type: operationCreate, Desired: openwrt.DNSRecord{Type: "A", Name: "a.foobar.com", IP: "4.4.4.4"}},
+				}))
+			})
+
+			It("should delete the targets removed", func() {
+				operations, err := reconcile(multi, &plan.Changes{
+					UpdateOld: []*endpoint.Endpoint{endpoint.NewEndpoint("a.foobar.com", endpoint.RecordTypeA, "1.1.1.1", "2.2.2.2")},
+					UpdateNew: []*endpoint.Endpoint{endpoint.NewEndpoint("a.foobar.com", endpoint.RecordTypeA, "2.2.2.2")},
+				}, MissingRecordPolicyError, "")
+				Expect(err).To(BeNil())
+				Expect(operations).To(Equal([]operation{
+					{Type: operationDelete, Current: multi["x"]},
+				}))
+			})
+
+			It("should delete every target", func() {
+				operations, err := reconcile(multi, &plan.Changes{
+					Delete: []*endpoint.Endpoint{endpoint.NewEndpoint("a.foobar.com", endpoint.RecordTypeA, "1.1.1.1", "2.2.2.2")},
+				}, MissingRecordPolicyError, "")
+				Expect(err).To(BeNil())
+				Expect(operations).To(Equal([]operation{
+					{Type: operationDelete, Current: multi["x"]},
+					{Type: operationDelete, Current: multi["y"]},
+				}))
+			})
+
+			It("should fail when the targets changed on the router", func() {
+				_, err := reconcile(multi, &plan.Changes{
+					UpdateOld: []*endpoint.Endpoint{endpoint.NewEndpoint("a.foobar.com", endpoint.RecordTypeA, "1.1.1.1")},
+					UpdateNew: []*endpoint.Endpoint{endpoint.NewEndpoint("a.foobar.com", endpoint.RecordTypeA, "3.3.3.3")},
+				}, MissingRecordPolicyError, "")
+				Expect(err).To(MatchError("record A a.foobar.com changed on the router: 1.1.1.1,2.2.2.2, expected 1.1.1.1"))
+			})
+
+			It("should return the targets in a single endpoint", func() {
+				endpoints := dnsRecords2Endpoints(multi, defaultTTL)
+				Expect(endpoints).To(HaveLen(1))
+				Expect(endpoints[0].DNSName).To(Equal("a.foobar.com"))
+				Expect(endpoints[0].Targets).To(Equal(endpoint.Targets{"1.1.1.1", "2.2.2.2"}))
+			})
+		})
+
+		Context("with an owner", func() {
+			owned := map[string]openwrt.DNSRecord{
+				"x": {Type: "A", Name: "a.foobar.com", IP: "1.1.1.1", Owner: "external-dns", Section: "x"},
+				"y": {Type: "A", Name: "b.foobar.com", IP: "1.1.1.1", Owner: "someone-else", Section: "y"},
+				"z": {Type: "A", Name: "c.foobar.com", IP: "1.1.1.1", Section: "z"},
+			}
+
+			It("should only delete owned records", func() {
+				held := testutil.ToFloat64(heldDeletions.WithLabelValues(holdReasonNotOwned))
+				operations, err := reconcile(owned, &plan.Changes{
+					Delete: []*endpoint.Endpoint{
+						endpoint.NewEndpoint("a.foobar.com", endpoint.RecordTypeA, "1.1.1.1"),
+						endpoint.NewEndpoint("b.foobar.com", endpoint.RecordTypeA, "1.1.1.1"),
+						endpoint.NewEndpoint("c.foobar.com", endpoint.RecordTypeA, "1.1.1.1"),
+					},
+				}, MissingRecordPolicyError, "external-dns")
+				Expect(err).To(BeNil())
+				Expect(operations).To(Equal([]operation{
+					{Type: operationDelete, Current: owned["x"]},
+				}))
+				Expect(testutil.ToFloat64(heldDeletions.WithLabelValues(holdReasonNotOwned))).To(Equal(held + 2))
+			})
+
+			It("should not replace records owned by someone else", func() {
+				held := testutil.ToFloat64(heldDeletions.WithLabelValues(holdReasonNotOwned))
+				created := testutil.ToFloat64(notOwnedChanges.WithLabelValues(string(operationCreate)))
+				updated := testutil.ToFloat64(notOwnedChanges.WithLabelValues(string(operationUpdate)))
+				operations, err := reconcile(owned, &plan.Changes{
+					Create:    []*endpoint.Endpoint{endpoint.NewEndpoint("b.foobar.com", endpoint.RecordTypeA, "2.2.2.2")},
+					UpdateOld: []*endpoint.Endpoint{endpoint.NewEndpoint("c.foobar.com", endpoint.RecordTypeA, "1.1.1.1")},
+					UpdateNew: []*endpoint.Endpoint{endpoint.NewEndpoint("d.foobar.com", endpoint.RecordTypeA, "1.1.1.1")},
+				}, MissingRecordPolicyError, "external-dns")
+				Expect(err).To(BeNil())
+				Expect(operations).To(BeEmpty())
+				Expect(testutil.ToFloat64(heldDeletions.WithLabelValues(holdReasonNotOwned))).To(Equal(held))
+				Expect(testutil.ToFloat64(notOwnedChanges.WithLabelValues(string(operationCreate)))).To(Equal(created + 1))
+				Expect(testutil.ToFloat64(notOwnedChanges.WithLabelValues(string(operationUpdate)))).To(Equal(updated + 1))
+			})
+
+			It("should stamp the records added and update records in place", func() {
+				operations, err := reconcile(owned, &plan.Changes{
+					Create:    []*endpoint.Endpoint{endpoint.NewEndpoint("d.foobar.com", endpoint.RecordTypeA, "4.4.4.4")},
+					UpdateOld: []*endpoint.Endpoint{endpoint.NewEndpoint("b.foobar.com", endpoint.RecordTypeA, "1.1.1.1")},
+					UpdateNew: []*endpoint.Endpoint{endpoint.NewEndpoint("b.foobar.com", endpoint.RecordTypeA, "2.2.2.2")},
+				}, MissingRecordPolicyError, "external-dns")
+				Expect(err).To(BeNil())
+				Expect(operations).To(Equal([]operation{
+					{Type: operationUpdate, Current: owned["y"], Desired: openwrt.DNSRecord{Type: "A", Name: "b.foobar.com", IP: "2.2.2.2", Owner: "external-dns"}},
+					{Type: operationCreate, Desired: openwrt.DNSRecord{Type: "A", Name: "d.foobar.com", IP: "4.4.4.4", Owner: "external-dns"}},
+				}))
+			})
+		})
+	})
+
+	It("should validate the records before reading any router", func() {
+		mockCtrl := gomock.NewController(GinkgoT())
+		defer mockCtrl.Finish()
+		p := &Provider{
+			config:  DefaultConfig(),
+			routers: []*router{newRouter("primary", mocks.NewMockOpenWRT(mockCtrl), DefaultConfig().Cache)},
+		}
+
+		err := p.ApplyChanges(context.Background(), &plan.Changes{
+			Create: []*endpoint.Endpoint{
+				endpoint.NewEndpoint("d foobar.com", endpoint.RecordTypeA, "4.4.4.4"),
+				endpoint.NewEndpoint("e.foobar.com", endpoint.RecordTypeA, "4.4.4"),
+			},
+		})
+		var validationErr *openwrt.ValidationError
+		Expect(errors.As(err, &validationErr)).To(BeTrue())
+		Expect(validationErr.Errors).To(HaveLen(2))
+	})
+
+	It("should count the writes of a dry run", func() {
+		writes := testutil.ToFloat64(dryRunWrites.WithLabelValues("primary", "uci set"))
+		dryRunObserver("primary")("uci set", []string{"dhcp", "cfg01", "ip", "1.1.1.1"})
+		Expect(testutil.ToFloat64(dryRunWrites.WithLabelValues("primary", "uci set"))).To(Equal(writes + 1))
+	})
+
+	Context("apply changes", func() {
+		var (
+			ctx         context.Context
+			mockCtrl    *gomock.Controller
+			mockOpenWRT *mocks.MockOpenWRT
+			p           *Provider
+			changes     *plan.Changes
+			errStage    = errors.New("stage failed")
+
+			current = map[string]openwrt.DNSRecord{
+				"y": {Type: "A", Name: "b.foobar.com", IP: "1.1.1.1", Owner: "external-dns", Section: "y"},
+				"z": {Type: "CNAME", CName: "c.foobar.com", Target: "a.foobar.com", Owner: "external-dns", Section: "z"},
+			}
+			created = openwrt.DNSRecord{Type: "A", Name: "a.foobar.com", IP: "1.1.1.1", Owner: "external-dns"}
+			updated = openwrt.DNSRecord{Type: "A", Name: "b.foobar.com", IP: "2.2.2.2", Owner: "external-dns"}
+		)
+
+		BeforeEach(func() {
+			ctx = context.Background()
+			mockCtrl = gomock.NewController(GinkgoT())
+			mockOpenWRT = mocks.NewMockOpenWRT(mockCtrl)
+			p = &Provider{
+				config:  DefaultConfig(),
+				routers: []*router{newRouter("primary", mockOpenWRT, DefaultConfig().Cache)},
+			}
+			changes = &plan.Changes{
+				Create:    []*endpoint.Endpoint{endpoint.NewEndpoint("a.foobar.com", endpoint.RecordTypeA, "1.1.1.1")},
+				UpdateOld: []*endpoint.Endpoint{endpoint.NewEndpoint("b.foobar.com", endpoint.RecordTypeA, "1.1.1.1")},
+				UpdateNew: []*endpoint.Endpoint{endpoint.NewEndpoint("b.foobar.com", endpoint.RecordTypeA, "2.2.2.2")},
+				Delete:    []*endpoint.Endpoint{endpoint.NewEndpoint("c.foobar.com", endpoint.RecordTypeCNAME, "a.foobar.com")},
+			}
+			mockOpenWRT.EXPECT().GetDNSRecords(ctx).Return(current, nil)
+		})
+
+		AfterEach(func() {
+			mockCtrl.Finish()
+		})
+
+		It("should commit once", func() {
+			gomock.InOrder(
+				mockOpenWRT.EXPECT().DeleteDNSRecord(ctx, current["z"]).Return(nil),
+				mockOpenWRT.EXPECT().UpdateDNSRecord(ctx, current["y"], updated).Return(nil),
+				mockOpenWRT.EXPECT().AddDNSRecord(ctx, created).Return(nil),
+				mockOpenWRT.EXPECT().Commit(ctx).Return(nil),
+				mockOpenWRT.EXPECT().Reload(ctx).Return(nil),
+			)
+
+			Expect(p.ApplyChanges(ctx, changes)).To(Succeed())
+		})
+
+		It("should succeed and retry the reload on the next apply when reload fails", func() {
+			failures := testutil.ToFloat64(reloadFailures.WithLabelValues("primary"))
+			gomock.InOrder(
+				mockOpenWRT.EXPECT().DeleteDNSRecord(ctx, gomock.Any()).Return(nil),
+				mockOpenWRT.EXPECT().UpdateDNSRecord(ctx, gomock.Any(), gomock.Any()).Return(nil),
+				mockOpenWRT.EXPECT().AddDNSRecord(ctx, gomock.Any()).Return(nil),
+				mockOpenWRT.EXPECT().Commit(ctx).Return(nil),
+				mockOpenWRT.EXPECT().Reload(ctx).Return(errStage),
+			)
+
+			Expect(p.ApplyChanges(ctx, changes)).To(Succeed())
+			Expect(testutil.ToFloat64(reloadFailures.WithLabelValues("primary"))).To(Equal(failures + 1))
+
+			gomock.InOrder(
+				mockOpenWRT.EXPECT().GetDNSRecords(ctx).Return(current, nil),
+				mockOpenWRT.EXPECT().Reload(ctx).Return(nil),
+			)
+			Expect(p.ApplyChanges(ctx, &plan.Changes{})).To(Succeed())
+			Expect(p.routers[0].reloadPending.Load()).To(BeFalse())
+		})
+
+		It("should revert instead of committing in dry run", func() {
+			p.config.DryRun = true
+			gomock.InOrder(
+				mockOpenWRT.EXPECT().DeleteDNSRecord(ctx, current["z"]).Return(nil),
+				mockOpenWRT.EXPECT().UpdateDNSRecord(ctx, current["y"], updated).Return(nil),
+				mockOpenWRT.EXPECT().AddDNSRecord(ctx, created).Return(nil),
+				mockOpenWRT.EXPECT().Revert(ctx).Return(nil),
+			)
+
+			Expect(p.ApplyChanges(ctx, changes)).To(Succeed())
+		})
+
+		It("should not commit without operations", func() {
+			Expect(p.ApplyChanges(ctx, &plan.Changes{})).To(Succeed())
+		})
+
+		It("should not stage anything when reconcile fails", func() {
+			changes.Delete = []*endpoint.Endpoint{endpoint.NewEndpoint("x.foobar.com", endpoint.RecordTypeA, "1.1.1.1")}
+			Expect(p.ApplyChanges(ctx, changes)).ToNot(Succeed())
+		})
+
+		It("should revert when delete fails", func() {
+			gomock.InOrder(
+				mockOpenWRT.EXPECT().DeleteDNSRecord(ctx, gomock.Any()).Return(errStage),
+				mockOpenWRT.EXPECT().Revert(ctx).Return(nil),
+			)
+
+			Expect(p.ApplyChanges(ctx, changes)).To(MatchError(errStage))
+		})
+
+		It("should revert when update fails", func() {
+			gomock.InOrder(
+				mockOpenWRT.EXPECT().DeleteDNSRecord(ctx, gomock.Any()).Return(nil),
+				mockOpenWRT.EXPECT().UpdateDNSRecord(ctx, gomock.Any(), gomock.Any()).Return(errStage),
+				mockOpenWRT.EXPECT().Revert(ctx).Return(nil),
+			)
+
+			Expect(p.ApplyChanges(ctx, changes)).To(MatchError(errStage))
+		})
+
+		It("should revert when create fails", func() {
+			gomock.InOrder(
+				mockOpenWRT.EXPECT().DeleteDNSRecord(ctx, gomock.Any()).Return(nil),
+				mockOpenWRT.EXPECT().UpdateDNSRecord(ctx, gomock.Any(), gomock.Any()).Return(nil),
+				mockOpenWRT.EXPECT().AddDNSRecord(ctx, gomock.Any()).Return(errStage),
+				mockOpenWRT.EXPECT().Revert(ctx).Return(nil),
+			)
+
+			Expect(p.ApplyChanges(ctx, changes)).To(MatchError(errStage))
+		})
+
+		It("should revert when commit fails", func() {
+			gomock.InOrder(
+				mockOpenWRT.EXPECT().DeleteDNSRecord(ctx, gomock.Any()).Return(nil),
+				mockOpenWRT.EXPECT().UpdateDNSRecord(ctx, gomock.Any(), gomock.Any()).Return(nil),
+				mockOpenWRT.EXPECT().AddDNSRecord(ctx, gomock.Any()).Return(nil),
+				mockOpenWRT.EXPECT().Commit(ctx).Return(errStage),
+				mockOpenWRT.EXPECT().Revert(ctx).Return(nil),
+			)
+
+			Expect(p.ApplyChanges(ctx, changes)).To(MatchError(errStage))
+		})
+
+		It("should return the stage error when revert fails", func() {
+			gomock.InOrder(
+				mockOpenWRT.EXPECT().DeleteDNSRecord(ctx, gomock.Any()).Return(errStage),
+				mockOpenWRT.EXPECT().Revert(ctx).Return(errors.New("revert failed")),
+			)
+
+			Expect(p.ApplyChanges(ctx, changes)).To(MatchError(errStage))
+		})
+
+		It("should update records whose labels changed only", func() {
+			labelled := endpoint.NewEndpoint("b.foobar.com", endpoint.RecordTypeA, "1.1.1.1")
+			labelled.Labels = endpoint.Labels{endpoint.ResourceLabelKey: "ingress/default/b"}
+			desired := current["y"]
+			desired.Section = ""
+			desired.Labels = map[string]string{endpoint.ResourceLabelKey: "ingress/default/b"}
+
+			gomock.InOrder(
+				mockOpenWRT.EXPECT().UpdateDNSRecord(ctx, current["y"], desired).Return(nil),
+				mockOpenWRT.EXPECT().Commit(ctx).Return(nil),
+				mockOpenWRT.EXPECT().Reload(ctx).Return(nil),
+			)
+
+			Expect(p.ApplyChanges(ctx, &plan.Changes{
+				UpdateOld: []*endpoint.Endpoint{endpoint.NewEndpoint("b.foobar.com", endpoint.RecordTypeA, "1.1.1.1")},
+				UpdateNew: []*endpoint.Endpoint{labelled},
+			})).To(Succeed())
+		})
+
+		It("should reject cname loops through the current records before staging any record", func() {
+			changes.Create = []*endpoint.Endpoint{
+				endpoint.NewEndpoint("a.foobar.com", endpoint.RecordTypeCNAME, "c.foobar.com"),
+			}
+			changes.Delete = nil
+
+			err := p.ApplyChanges(ctx, changes)
+			var validationErr *openwrt.ValidationError
+			Expect(errors.As(err, &validationErr)).To(BeTrue())
+			Expect(err).To(MatchError(ContainSubstring("cname loop a.foobar.com -> c.foobar.com -> a.foobar.com")))
+		})
 	})
 })
@@ -0,0 +1,449 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/renanqts/external-dns-openwrt-webhook/pkg/logger"
+	"github.com/renanqts/external-dns-openwrt-webhook/pkg/openwrt"
+	"go.uber.org/zap"
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+type operationType string
+
+const (
+	operationCreate operationType = "create"
+	operationUpdate operationType = "update"
+	operationDelete operationType = "delete"
+)
+
+// operation is a single record change on the router,
+// Current is empty on create and Desired is empty on delete
+type operation struct {
+	Type    operationType
+	Current openwrt.DNSRecord
+	Desired openwrt.DNSRecord
+}
+
+func (o operation) String() string {
+	switch o.Type {
+	case operationCreate:
+		return fmt.Sprintf("%s %s", o.Type, formatRecord(o.Desired))
+	case operationUpdate:
+		return fmt.Sprintf("%s %s -> %s", o.Type, formatRecord(o.Current), recordTarget(o.Desired))
+	default:
+		return fmt.Sprintf("%s %s", o.Type, formatRecord(o.Current))
+	}
+}
+
+// reconcile computes the ordered operations turning the records on the router into
+// the state requested by changes. Records already in the desired state are skipped and
+// deletes run before updates and creates, so a conflicting record is removed before
+// its replacement is added. Updates and deletes of records which are not on the
+// router are handled according to the missing record policy.
+//
+// An endpoint holds a record per target. Updates pair UpdateOld and UpdateNew by
+// position, the old records have to point to the same targets on the router. Records
+// of a target which is not desired anymore are moved to the new targets in place, and
+// a record renamed or changing type is replaced.
+//
+// Records added are stamped with the owner. When the owner is set, records owned by
+// anyone else are never deleted nor replaced, they can only be updated in place.
+//
+// Partial records, missing or different on some routers, are repaired: their update
+// creates them when they are missing and overwrites them whatever their target, their
+// delete skips the routers missing them.
+func reconcile(records map[string]openwrt.DNSRecord, changes *plan.Changes, missingRecordPolicy, owner string) ([]operation, error) {
+	if err := checkUpdates(changes); err != nil {
+		return nil, err
+	}
+
+	index := openwrt.NewRecordSets(records)
+	planned := make(map[openwrt.RecordKey]bool)
+
+	var (
+		deletes, updates, creates []operation
+		notFound                  []string
+	)
+
+	for _, ep := range changes.Delete {
+		records, ok := endpoint2DNSRecords(ep)
+		if !ok {
+			continue
+		}
+
+		for _, record := range records {
+			current, ok := index.Find(record)
+			if !ok {
+				if isPartial(ep) {
+					logger.Log.Debug("partial record already deleted", zap.String("record", formatRecord(record)))
+					continue
+				}
+
+				if missingRecordPolicy == MissingRecordPolicyError {
+					notFound = append(notFound, formatRecord(record))
+					continue
+				}
+
+				skipMissing(operationDelete, record, missingRecordPolicy)
+				continue
+			}
+
+			if !owns(owner, current) {
+				skipNotOwned(operationDelete, current)
+				continue
+			}
+
+			deletes = append(deletes, operation{Type: operationDelete, Current: current})
+			index.Remove(current)
+		}
+	}
+
+	for i, ep := range changes.UpdateNew {
+		old, oldOk := endpoint2DNSRecords(changes.UpdateOld[i])
+		desired, ok := endpoint2DNSRecords(ep)
+		if !oldOk || !ok {
+			continue
+		}
+		stamp(desired, owner)
+		oldKey, key := old[0].Key(), desired[0].Key()
+
+		if planned[key] {
+			return nil, fmt.Errorf("conflicting changes for %s", formatRecord(desired[0]))
+		}
+		planned[key] = true
+
+		partial := isPartial(changes.UpdateOld[i])
+		current := index[oldKey]
+		if len(current) == 0 && partial {
+			logger.Log.Info("repairing partial record", zap.String("record", formatRecords(desired)))
+			replaced, added, ok := replaceSet(index[key], desired, owner)
+			if ok {
+				deletes = append(deletes, replaced...)
+				creates = append(creates, added...)
+				delete(index, key)
+			}
+			continue
+		}
+
+		if len(current) == 0 {
+			switch missingRecordPolicy {
+			case MissingRecordPolicyError:
+				notFound = append(notFound, formatRecords(old))
+			case MissingRecordPolicyRecreateOnUpdate:
+				logger.Log.Warn("recreating missing record", zap.String("record", formatRecords(desired)))
+				for _, record := range desired {
+					creates = append(creates, operation{Type: operationCreate, Desired: record})
+				}
+			default:
+				skipMissing(operationUpdate, old[0], missingRecordPolicy)
+			}
+			continue
+		}
+
+		if oldKey == key && equalSets(current, desired) {
+			logger.Log.Debug("record unchanged", zap.String("record", formatRecords(desired)))
+			continue
+		}
+
+		// the plan was computed from records which have changed since,
+		// partial records differ between routers by definition
+		if !partial && recordTargets(current) != recordTargets(old) {
+			return nil, fmt.Errorf("record %s %s changed on the router: %s, expected %s", oldKey.Type, oldKey.Name,
+				recordTargets(current), recordTargets(old))
+		}
+
+		if oldKey == key {
+			moved, changed, added := updateSet(current, desired, owner)
+			deletes = append(deletes, moved...)
+			updates = append(updates, changed...)
+			creates = append(creates, added...)
+			continue
+		}
+
+		// renamed or changing type, the record is replaced
+		if record, ok := notOwned(owner, current); ok {
+			skipNotOwned(operationUpdate, record)
+			continue
+		}
+
+		replaced, added, ok := replaceSet(index[key], desired, owner)
+		if !ok {
+			continue
+		}
+
+		for _, record := range current {
+			deletes = append(deletes, operation{Type: operationDelete, Current: record})
+		}
+		delete(index, oldKey)
+		deletes = append(deletes, replaced...)
+		creates = append(creates, added...)
+		delete(index, key)
+	}
+
+	for _, ep := range changes.Create {
+		desired, ok := endpoint2DNSRecords(ep)
+		if !ok {
+			continue
+		}
+		stamp(desired, owner)
+		key := desired[0].Key()
+
+		if planned[key] {
+			return nil, fmt.Errorf("conflicting changes for %s", formatRecord(desired[0]))
+		}
+		planned[key] = true
+
+		// the records of other targets are replaced
+		replaced, added, ok := replaceSet(index[key], desired, owner)
+		if ok {
+			deletes = append(deletes, replaced...)
+			creates = append(creates, added...)
+		}
+	}
+
+	if len(notFound) > 0 {
+		return nil, fmt.Errorf("records not found: %s", strings.Join(notFound, ", "))
+	}
+
+	operations := make([]operation, 0, len(deletes)+len(updates)+len(creates))
+	operations = append(operations, deletes...)
+	operations = append(operations, updates...)
+	operations = append(operations, creates...)
+
+	return operations, nil
+}
+
+// replaceSet returns the operations replacing the records of a name with desired, records
+// already in the desired state are kept. It returns false, replacing nothing, when a record
+// owned by someone else would be deleted.
+func replaceSet(current, desired []openwrt.DNSRecord, owner string) ([]operation, []operation, bool) {
+	var deletes, creates []operation
+	for _, record := range current {
+		if slices.ContainsFunc(desired, record.Equal) {
+			continue
+		}
+
+		if !owns(owner, record) {
+			skipNotOwned(operationCreate, record)
+			return nil, nil, false
+		}
+		deletes = append(deletes, operation{Type: operationDelete, Current: record})
+	}
+
+	for _, record := range desired {
+		if slices.ContainsFunc(current, record.Equal) {
+			logger.Log.Debug("record already exists", zap.String("record", formatRecord(record)))
+			continue
+		}
+		creates = append(creates, operation{Type: operationCreate, Desired: record})
+	}
+
+	return deletes, creates, true
+}
+
+// updateSet returns the operations turning the records of a name into desired in place.
+// Records of a target which is not desired anymore are moved to the new targets,
+// the ones left over are deleted when the owner owns them.
+func updateSet(current, desired []openwrt.DNSRecord, owner string) ([]operation, []operation, []operation) {
+	var deletes, updates, creates []operation
+	var removed, added []openwrt.DNSRecord
+	for _, record := range current {
+		if !slices.ContainsFunc(desired, sameTarget(record)) {
+			removed = append(removed, record)
+		}
+	}
+
+	for _, record := range desired {
+		i := slices.IndexFunc(current, sameTarget(record))
+		switch {
+		case i < 0:
+			added = append(added, record)
+		case !current[i].Equal(record):
+			updates = append(updates, operation{Type: operationUpdate, Current: current[i], Desired: record})
+		}
+	}
+
+	for len(removed) > 0 && len(added) > 0 {
+		updates = append(updates, operation{Type: operationUpdate, Current: removed[0], Desired: added[0]})
+		removed, added = removed[1:], added[1:]
+	}
+
+	for _, record := range removed {
+		if !owns(owner, record) {
+			skipNotOwned(operationUpdate, record)
+			continue
+		}
+		deletes = append(deletes, operation{Type: operationDelete, Current: record})
+	}
+
+	for _, record := range added {
+		creates = append(creates, operation{Type: operationCreate, Desired: record})
+	}
+
+	return deletes, updates, creates
+}
+
+// equalSets reports whether both sets hold the same records, whatever their order
+func equalSets(a, b []openwrt.DNSRecord) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for _, record := range a {
+		if !slices.ContainsFunc(b, record.Equal) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// stamp sets the owner of the records added or updated
+func stamp(records []openwrt.DNSRecord, owner string) {
+	for i := range records {
+		records[i].Owner = owner
+	}
+}
+
+// sameTarget returns a func reporting whether a record points to the same target as record
+func sameTarget(record openwrt.DNSRecord) func(openwrt.DNSRecord) bool {
+	return func(other openwrt.DNSRecord) bool {
+		return other.Value() == record.Value()
+	}
+}
+
+// checkUpdates checks every new endpoint has its old endpoint at the same position
+func checkUpdates(changes *plan.Changes) error {
+	if len(changes.UpdateOld) != len(changes.UpdateNew) {
+		return fmt.Errorf("mismatched updates: %d old and %d new endpoints", len(changes.UpdateOld), len(changes.UpdateNew))
+	}
+
+	return nil
+}
+
+// skipMissing reports a record which is not on the router and is left out of the batch
+func skipMissing(opType operationType, record openwrt.DNSRecord, missingRecordPolicy string) {
+	skippedRecords.WithLabelValues(string(opType)).Inc()
+	if missingRecordPolicy == MissingRecordPolicyIgnore {
+		logger.Log.Debug("skipping missing record", zap.String("operation", string(opType)),
+			zap.String("record", formatRecord(record)))
+		return
+	}
+
+	logger.Log.Warn("skipping missing record", zap.String("operation", string(opType)),
+		zap.String("record", formatRecord(record)))
+}
+
+// isPartial reports whether the endpoint was returned as missing or different on some routers
+func isPartial(ep *endpoint.Endpoint) bool {
+	_, ok := ep.GetProviderSpecificProperty(providerSpecificPartial)
+	return ok
+}
+
+// owns reports whether the record can be deleted or replaced by the owner
+func owns(owner string, record openwrt.DNSRecord) bool {
+	return owner == "" || record.Owner == owner
+}
+
+// notOwned returns the first record the owner cannot delete nor replace
+func notOwned(owner string, records []openwrt.DNSRecord) (openwrt.DNSRecord, bool) {
+	for _, record := range records {
+		if !owns(owner, record) {
+			return record, true
+		}
+	}
+
+	return openwrt.DNSRecord{}, false
+}
+
+// skipNotOwned reports a record owned by someone else which is left out of the batch
+func skipNotOwned(opType operationType, record openwrt.DNSRecord) {
+	notOwnedChanges.WithLabelValues(string(opType)).Inc()
+	if opType == operationDelete {
+		heldDeletions.WithLabelValues(holdReasonNotOwned).Inc()
+	}
+	logger.Log.Warn("skipping record not owned", zap.String("operation", string(opType)),
+		zap.String("record", formatRecord(record)), zap.String("owner", record.Owner))
+}
+
+// validateChanges checks the records written by the changes on their own, before any router is read
+func validateChanges(changes *plan.Changes) error {
+	var written []openwrt.DNSRecord
+	for _, ep := range slices.Concat(changes.Create, changes.UpdateNew) {
+		records, _ := endpoint2DNSRecords(ep)
+		written = append(written, records...)
+	}
+
+	return openwrt.Validate(written, nil)
+}
+
+// validateCNAMEs follows the CNAME chains of the records written by the operations
+// through the records left on the router, before the router is touched
+func validateCNAMEs(records map[string]openwrt.DNSRecord, operations []operation) error {
+	index := openwrt.NewIndex(records)
+	for _, op := range operations {
+		if op.Type != operationCreate {
+			delete(index, op.Current.Key())
+		}
+	}
+
+	var written []openwrt.DNSRecord
+	for _, op := range operations {
+		if op.Type != operationDelete {
+			index[op.Desired.Key()] = op.Desired
+			written = append(written, op.Desired)
+		}
+	}
+
+	return openwrt.ValidateCNAMEs(written, index)
+}
+
+// execute stages the operations in order, stopping at the first failure
+func (r *router) execute(ctx context.Context, operations []operation) error {
+	for _, op := range operations {
+		var err error
+		switch op.Type {
+		case operationCreate:
+			err = r.openwrt.AddDNSRecord(ctx, op.Desired)
+		case operationUpdate:
+			err = r.openwrt.UpdateDNSRecord(ctx, op.Current, op.Desired)
+		case operationDelete:
+			err = r.openwrt.DeleteDNSRecord(ctx, op.Current)
+		}
+
+		if err != nil {
+			return fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	return nil
+}
+
+func formatRecord(record openwrt.DNSRecord) string {
+	return fmt.Sprintf("%s %s %s", record.Key().Type, record.Key().Name, recordTarget(record))
+}
+
+// formatRecords formats the records of a name along with all of their targets
+func formatRecords(records []openwrt.DNSRecord) string {
+	return fmt.Sprintf("%s %s %s", records[0].Key().Type, records[0].Key().Name, recordTargets(records))
+}
+
+func recordTarget(record openwrt.DNSRecord) string {
+	return record.Value()
+}
+
+// recordTargets returns the sorted targets of the records of a name
+func recordTargets(records []openwrt.DNSRecord) string {
+	targets := make([]string, 0, len(records))
+	for _, record := range records {
+		targets = append(targets, record.Value())
+	}
+	slices.Sort(targets)
+
+	return strings.Join(targets, ",")
+}
@@ -0,0 +1,205 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+// nameRule replaces the names matched by pattern
+type nameRule struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+func (r nameRule) apply(name string) (string, bool) {
+	match := r.pattern.FindStringSubmatchIndex(name)
+	if match == nil {
+		return name, false
+	}
+
+	return string(r.pattern.ExpandString(nil, r.replacement, name, match)), true
+}
+
+// nameRewriter renames names with the first matching rule,
+// names read from the routers with the first matching reverse rule
+type nameRewriter struct {
+	rules, reverse []nameRule
+}
+
+func newNameRewriter(rules []RewriteRule) (*nameRewriter, error) {
+	rewriter := &nameRewriter{}
+	for i, rule := range rules {
+		forward, reverse, err := rule.compile()
+		if err != nil {
+			return nil, fmt.Errorf("invalid rewrite rule %d: %w", i, err)
+		}
+		rewriter.rules = append(rewriter.rules, forward)
+		rewriter.reverse = append(rewriter.reverse, reverse)
+	}
+
+	return rewriter, nil
+}
+
+// compile returns the rules renaming names and renaming them back,
+// a suffix rule is a regex rule matching the domain and its subdomains
+func (r RewriteRule) compile() (nameRule, nameRule, error) {
+	if (r.Suffix == "") == (r.Regex == "") {
+		return nameRule{}, nameRule{}, fmt.Errorf("either suffix or regex is required")
+	}
+
+	if r.Suffix != "" {
+		suffix, replacement := normalizeDomain(r.Suffix), normalizeDomain(r.Replacement)
+		if replacement == "" {
+			return nameRule{}, nameRule{}, fmt.Errorf("replacement of suffix %s is required", r.Suffix)
+		}
+
+		return nameRule{pattern: suffixPattern(suffix), replacement: "${1}" + replacement},
+			nameRule{pattern: suffixPattern(replacement), replacement: "${1}" + suffix}, nil
+	}
+
+	if r.ReverseRegex == "" {
+		return nameRule{}, nameRule{}, fmt.Errorf("reverse_regex of regex %s is required", r.Regex)
+	}
+
+	pattern, err := regexp.Compile("^(?:" + r.Regex + ")$")
+	if err != nil {
+		return nameRule{}, nameRule{}, fmt.Errorf("invalid regex: %w", err)
+	}
+
+	reverse, err := regexp.Compile("^(?:" + r.ReverseRegex + ")$")
+	if err != nil {
+		return nameRule{}, nameRule{}, fmt.Errorf("invalid reverse_regex: %w", err)
+	}
+
+	return nameRule{pattern: pattern, replacement: r.Replacement},
+		nameRule{pattern: reverse, replacement: r.ReverseReplacement}, nil
+}
+
+// write returns the name stored on the routers, it fails when the
+// name would not be read back as is
+func (r *nameRewriter) write(name string) (string, error) {
+	if r == nil || len(r.rules) == 0 {
+		return name, nil
+	}
+
+	written := rewrite(r.rules, name)
+	if read := rewrite(r.reverse, written); read != name {
+		return "", fmt.Errorf("rewriting %s to %s does not round-trip, it is read back as %s", name, written, read)
+	}
+
+	return written, nil
+}
+
+// read returns the name of a record read from the routers
+func (r *nameRewriter) read(name string) string {
+	if r == nil {
+		return name
+	}
+
+	return rewrite(r.reverse, name)
+}
+
+func rewrite(rules []nameRule, name string) string {
+	for _, rule := range rules {
+		if renamed, ok := rule.apply(name); ok {
+			return renamed
+		}
+	}
+
+	return name
+}
+
+// renameChanges returns a copy of the changes with the names of the endpoints and their
+// CNAME targets renamed, it fails on any name which cannot be renamed
+func renameChanges(changes *plan.Changes, rename func(string) (string, error)) (*plan.Changes, error) {
+	return mapChanges(changes, func(ep *endpoint.Endpoint) (*endpoint.Endpoint, error) {
+		return renameEndpoint(ep, rename)
+	})
+}
+
+// mapChanges returns a copy of the changes with every endpoint mapped,
+// it fails with the errors of every endpoint which cannot be mapped
+func mapChanges(changes *plan.Changes, mapEndpoint func(*endpoint.Endpoint) (*endpoint.Endpoint, error)) (*plan.Changes, error) {
+	var errs []error
+	mapAll := func(endpoints []*endpoint.Endpoint) []*endpoint.Endpoint {
+		mapped := make([]*endpoint.Endpoint, 0, len(endpoints))
+		for _, ep := range endpoints {
+			ep, err := mapEndpoint(ep)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			mapped = append(mapped, ep)
+		}
+
+		return mapped
+	}
+
+	mapped := &plan.Changes{
+		Create:    mapAll(changes.Create),
+		UpdateOld: mapAll(changes.UpdateOld),
+		UpdateNew: mapAll(changes.UpdateNew),
+		Delete:    mapAll(changes.Delete),
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return mapped, nil
+}
+
+// renameEndpoint returns the endpoint with its name and CNAME targets renamed,
+// it is copied only when one of them changes
+func renameEndpoint(ep *endpoint.Endpoint, rename func(string) (string, error)) (*endpoint.Endpoint, error) {
+	name, err := rename(ep.DNSName)
+	if err != nil {
+		return nil, err
+	}
+
+	changed := name != ep.DNSName
+	targets := ep.Targets
+	if ep.RecordType == endpoint.RecordTypeCNAME {
+		targets = make(endpoint.Targets, len(ep.Targets))
+		for i, target := range ep.Targets {
+			if targets[i], err = rename(target); err != nil {
+				return nil, err
+			}
+			changed = changed || targets[i] != target
+		}
+	}
+
+	if !changed {
+		return ep, nil
+	}
+
+	ep = ep.DeepCopy()
+	ep.DNSName = name
+	ep.Targets = targets
+	return ep, nil
+}
+
+// renameEndpoints renames the endpoints and their CNAME targets in place
+func renameEndpoints(endpoints []*endpoint.Endpoint, rename func(string) string) {
+	for _, ep := range endpoints {
+		ep.DNSName = rename(ep.DNSName)
+		if ep.RecordType == endpoint.RecordTypeCNAME {
+			for i, target := range ep.Targets {
+				ep.Targets[i] = rename(target)
+			}
+		}
+	}
+}
+
+// suffixPattern matches the domain and its subdomains, capturing the subdomain with its dot
+func suffixPattern(domain string) *regexp.Regexp {
+	return regexp.MustCompile(`^(.*\.)?` + regexp.QuoteMeta(domain) + `$`)
+}
+
+func normalizeDomain(domain string) string {
+	return strings.ToLower(strings.Trim(domain, "."))
+}
@@ -0,0 +1,123 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	mocks "github.com/renanqts/external-dns-openwrt-webhook/internal/mocks/openwrt"
+	"github.com/renanqts/external-dns-openwrt-webhook/pkg/openwrt"
+	"go.uber.org/mock/gomock"
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+var _ = Describe("Name rewriting", func() {
+	var (
+		ctx         context.Context
+		mockCtrl    *gomock.Controller
+		mockOpenWRT *mocks.MockOpenWRT
+		p           *Provider
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockOpenWRT = mocks.NewMockOpenWRT(mockCtrl)
+		config := DefaultConfig()
+		config.Sync.OwnerID = ""
+		config.Rewrite = []RewriteRule{
+			{Suffix: "example.com", Replacement: "home.lan"},
+			{Regex: `(.+)\.svc\.cluster\.local`, Replacement: "${1}.k8s.lan", ReverseRegex: `(.+)\.k8s\.lan`, ReverseReplacement: "${1}.svc.cluster.local"},
+		}
+		rewriter, err := newNameRewriter(config.Rewrite)
+		Expect(err).To(BeNil())
+		p = &Provider{
+			config:   config,
+			routers:  []*router{newRouter("primary", mockOpenWRT, config.Cache)},
+			rewriter: rewriter,
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	It("should rename names with the first matching rule and back", func() {
+		for name, written := range map[string]string{
+			"example.com":                   "home.lan",
+			"a.b.example.com":               "a.b.home.lan",
+			"*.apps.example.com":            "*.apps.home.lan",
+			"web.default.svc.cluster.local": "web.default.k8s.lan",
+			"a.other.lan":                   "a.other.lan",
+		} {
+			rewritten, err := p.rewriter.write(name)
+			Expect(err).To(BeNil())
+			Expect(rewritten).To(Equal(written))
+			Expect(p.rewriter.read(rewritten)).To(Equal(name))
+		}
+	})
+
+	It("should reject names which do not round-trip", func() {
+		_, err := p.rewriter.write("a.home.lan")
+		Expect(err).To(MatchError("rewriting a.home.lan to a.home.lan does not round-trip, it is read back as a.example.com"))
+	})
+
+	It("should write the rewritten names", func() {
+		gomock.InOrder(
+			mockOpenWRT.EXPECT().GetDNSRecords(ctx).Return(map[string]openwrt.DNSRecord{}, nil),
+			mockOpenWRT.EXPECT().AddDNSRecord(ctx, openwrt.DNSRecord{Type: "CNAME", CName: "www.home.lan", Target: "web.default.k8s.lan"}).Return(nil),
+			mockOpenWRT.EXPECT().Commit(ctx).Return(nil),
+			mockOpenWRT.EXPECT().Reload(ctx).Return(nil),
+		)
+
+		changes := &plan.Changes{Create: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("www.example.com", endpoint.RecordTypeCNAME, "web.default.svc.cluster.local"),
+		}}
+		Expect(p.ApplyChanges(ctx, changes)).To(Succeed())
+		Expect(changes.Create[0].DNSName).To(Equal("www.example.com"))
+	})
+
+	It("should reject changes which do not round-trip before reading the routers", func() {
+		Expect(p.ApplyChanges(ctx, &plan.Changes{
+			Create: []*endpoint.Endpoint{endpoint.NewEndpoint("a.home.lan", endpoint.RecordTypeA, "192.168.1.10")},
+		})).To(MatchError(ContainSubstring("does not round-trip")))
+	})
+
+	It("should return the names renamed back", func() {
+		mockOpenWRT.EXPECT().GetDNSRecords(ctx).Return(map[string]openwrt.DNSRecord{
+			"x": {Type: "CNAME", CName: "www.home.lan", Target: "web.default.k8s.lan", Section: "x"},
+			"y": {Type: "A", Name: "a.other.lan", IP: "192.168.1.10", Section: "y"},
+		}, nil)
+
+		endpoints, err := p.Records(ctx)
+		Expect(err).To(BeNil())
+		Expect(endpoints).To(HaveLen(2))
+		Expect(endpoints[0].DNSName).To(Equal("a.other.lan"))
+		Expect(endpoints[1].DNSName).To(Equal("www.example.com"))
+		Expect(endpoints[1].Targets).To(Equal(endpoint.Targets{"web.default.svc.cluster.local"}))
+	})
+
+	It("should advertise the domains under both names", func() {
+		p.config.DomainFilter = &DomainFilterConfig{Include: []string{"home.lan"}}
+
+		b, err := json.Marshal(p.GetDomainFilter())
+		Expect(err).To(BeNil())
+		Expect(string(b)).To(Equal(`{"include":["example.com","home.lan"]}`))
+	})
+
+	It("should reject invalid rules", func() {
+		config := DefaultConfig()
+		for rule, message := range map[*RewriteRule]string{
+			{Suffix: "example.com"}:                               "invalid rewrite rule 0: replacement of suffix example.com is required",
+			{Suffix: "example.com", Regex: "a", Replacement: "b"}: "invalid rewrite rule 0: either suffix or regex is required",
+			{Regex: "(.+)", Replacement: "${1}"}:                  "invalid rewrite rule 0: reverse_regex of regex (.+) is required",
+			{Regex: "(", ReverseRegex: "(.+)"}:                    "invalid rewrite rule 0: invalid regex",
+			{Regex: "(.+)", ReverseRegex: "["}:                    "invalid rewrite rule 0: invalid reverse_regex",
+		} {
+			config.Rewrite = []RewriteRule{*rule}
+			Expect(config.validate()).To(MatchError(ContainSubstring(message)))
+		}
+	})
+})
@@ -0,0 +1,151 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/renanqts/external-dns-openwrt-webhook/pkg/logger"
+	"github.com/renanqts/external-dns-openwrt-webhook/pkg/openwrt"
+	"go.uber.org/zap"
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+// router is an OpenWrt router receiving the changes
+type router struct {
+	name    string
+	backend string
+	openwrt openwrt.OpenWRT
+	cache   *recordCache
+	// domains routes changes to the router, it receives every change when empty
+	domains endpoint.DomainFilter
+	// reloadPending is set while committed changes are not served by the DNS server
+	reloadPending atomic.Bool
+}
+
+func newRouter(name string, opwrt openwrt.OpenWRT, cacheConfig *CacheConfig) *router {
+	return &router{
+		name:    name,
+		backend: openwrt.BackendDnsmasq,
+		openwrt: opwrt,
+		cache:   newRecordCache(cacheConfig, opwrt),
+	}
+}
+
+// filter returns the changes of the domains of the router
+func (r *router) filter(changes *plan.Changes) *plan.Changes {
+	return filterChanges(changes, r.domains)
+}
+
+// forEachRouter runs fn against the routers at once and settles
+// the failures according to the failure policy
+func (p *Provider) forEachRouter(ctx context.Context, operation string, routers []*router, fn func(context.Context, *router) error) error {
+	errs := make([]error, len(routers))
+	var wg sync.WaitGroup
+	for i, r := range routers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = fn(ctx, r)
+		}()
+	}
+	wg.Wait()
+
+	var failed []error
+	for i, r := range routers {
+		if errs[i] != nil {
+			logger.Log.Error("router failed", zap.String("router", r.name), zap.String("operation", operation), zap.Error(errs[i]))
+			routerOperations.WithLabelValues(r.name, operation, routerResultFailure).Inc()
+			routerUp.WithLabelValues(r.name).Set(0)
+			failed = append(failed, fmt.Errorf("%s: %w", r.name, errs[i]))
+			continue
+		}
+
+		routerOperations.WithLabelValues(r.name, operation, routerResultSuccess).Inc()
+		routerUp.WithLabelValues(r.name).Set(1)
+	}
+
+	if len(failed) == 0 || !p.tolerates(len(failed), len(routers)) {
+		return errors.Join(failed...)
+	}
+
+	logger.Log.Warn("routers failed", zap.String("operation", operation), zap.String("failure_policy", p.config.FailurePolicy),
+		zap.Int("failed", len(failed)), zap.Int("routers", len(routers)))
+	return nil
+}
+
+// tolerates reports whether an operation succeeds when failed out of routers did not succeed
+func (p *Provider) tolerates(failed, routers int) bool {
+	switch p.config.FailurePolicy {
+	case FailurePolicyBestEffort:
+		return failed < routers
+	case FailurePolicyQuorum:
+		return (routers-failed)*2 > routers
+	default:
+		return false
+	}
+}
+
+// consistentRecords returns the records found on the routers of their domain, records of
+// other domains are ignored. Records missing or different on some routers are returned as
+// partial when the owner owns them, so external-dns either updates them, which writes them
+// again on every router, or deletes them. The others are left out, external-dns creates
+// them again and the routers holding them already skip the change. Static leases are
+// DHCP config first, only those the owner owns are returned.
+func consistentRecords(routers []*router, snapshots []map[string]openwrt.DNSRecord, owner string) (map[string]openwrt.DNSRecord, map[string]bool) {
+	sets := make([]openwrt.RecordSets, len(snapshots))
+	var keys []openwrt.RecordKey
+	seen := make(map[openwrt.RecordKey]bool)
+	for i, snapshot := range snapshots {
+		sets[i] = openwrt.NewRecordSets(snapshot)
+		for key := range sets[i] {
+			if !seen[key] && routers[i].domains.Match(key.Name) {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	records := make(map[string]openwrt.DNSRecord, len(keys))
+	partial := make(map[string]bool)
+	for _, key := range keys {
+		var set []openwrt.DNSRecord
+		ok, found := true, false
+		for i := range sets {
+			if !routers[i].domains.Match(key.Name) {
+				continue
+			}
+
+			other, exists := sets[i][key]
+			if !exists || (found && !equalSets(other, set)) {
+				logger.Log.Warn("record differs between routers", zap.String("record", key.Type+" "+key.Name),
+					zap.String("router", routers[i].name))
+				ok = false
+			}
+
+			if exists && !found {
+				set, found = other, true
+			}
+		}
+
+		if !ok {
+			partial[key.Type+" "+key.Name] = true
+			if _, ok := notOwned(owner, set); ok {
+				continue
+			}
+		}
+
+		for i, record := range set {
+			if record.MAC != "" && !owns(owner, record) {
+				continue
+			}
+			records[fmt.Sprintf("%s %s %d", key.Type, key.Name, i)] = record
+		}
+	}
+	inconsistentRecords.Set(float64(len(partial)))
+
+	return records, partial
+}
@@ -0,0 +1,339 @@
+package provider
+
+import (
+	"context"
+	"errors"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	mocks "github.com/renanqts/external-dns-openwrt-webhook/internal/mocks/openwrt"
+	"github.com/renanqts/external-dns-openwrt-webhook/pkg/openwrt"
+	"go.uber.org/mock/gomock"
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+var _ = Describe("Routers", func() {
+	var (
+		ctx      context.Context
+		mockCtrl *gomock.Controller
+		mockOpen []*mocks.MockOpenWRT
+		p        *Provider
+
+		errRouter = errors.New("router failed")
+		record    = openwrt.DNSRecord{Type: "A", Name: "a.foobar.com", IP: "1.1.1.1", Owner: defaultOwnerID, Section: "x"}
+		changes   = &plan.Changes{
+			Create: []*endpoint.Endpoint{endpoint.NewEndpoint("a.foobar.com", endpoint.RecordTypeA, "1.1.1.1")},
+		}
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		mockCtrl = gomock.NewController(GinkgoT())
+		p = &Provider{config: DefaultConfig()}
+		mockOpen = nil
+		for _, name := range []string{"primary", "secondary", "backup"} {
+			mockOpenWRT := mocks.NewMockOpenWRT(mockCtrl)
+			mockOpen = append(mockOpen, mockOpenWRT)
+			p.routers = append(p.routers, newRouter(name, mockOpenWRT, p.config.Cache))
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	// expectApply expects the record to be created, or the router to fail reading its records
+	expectApply := func(mockOpenWRT *mocks.MockOpenWRT, fail bool) {
+		if fail {
+			mockOpenWRT.EXPECT().GetDNSRecords(ctx).Return(nil, errRouter)
+			return
+		}
+
+		gomock.InOrder(
+			mockOpenWRT.EXPECT().GetDNSRecords(ctx).Return(map[string]openwrt.DNSRecord{}, nil),
+			mockOpenWRT.EXPECT().AddDNSRecord(ctx, gomock.Any()).Return(nil),
+			mockOpenWRT.EXPECT().Commit(ctx).Return(nil),
+			mockOpenWRT.EXPECT().Reload(ctx).Return(nil),
+		)
+	}
+
+	It("should apply changes to every router", func() {
+		for _, mockOpenWRT := range mockOpen {
+			expectApply(mockOpenWRT, false)
+		}
+
+		Expect(p.ApplyChanges(ctx, changes)).To(Succeed())
+		Expect(testutil.ToFloat64(routerUp.WithLabelValues("backup"))).To(Equal(1.0))
+	})
+
+	It("should fail when any router fails", func() {
+		expectApply(mockOpen[0], false)
+		expectApply(mockOpen[1], true)
+		expectApply(mockOpen[2], false)
+
+		err := p.ApplyChanges(ctx, changes)
+		Expect(err).To(MatchError(errRouter))
+		Expect(err).To(MatchError(ContainSubstring("secondary")))
+		Expect(testutil.ToFloat64(routerUp.WithLabelValues("secondary"))).To(BeZero())
+	})
+
+	It("should tolerate failures on best effort", func() {
+		p.config.FailurePolicy = FailurePolicyBestEffort
+		expectApply(mockOpen[0], true)
+		expectApply(mockOpen[1], true)
+		expectApply(mockOpen[2], false)
+		Expect(p.ApplyChanges(ctx, changes)).To(Succeed())
+
+		for _, mockOpenWRT := range mockOpen {
+			expectApply(mockOpenWRT, true)
+		}
+		Expect(p.ApplyChanges(ctx, changes)).To(MatchError(errRouter))
+	})
+
+	It("should require a majority on quorum", func() {
+		p.config.FailurePolicy = FailurePolicyQuorum
+		expectApply(mockOpen[0], false)
+		expectApply(mockOpen[1], true)
+		expectApply(mockOpen[2], false)
+		Expect(p.ApplyChanges(ctx, changes)).To(Succeed())
+
+		expectApply(mockOpen[0], false)
+		expectApply(mockOpen[1], true)
+		expectApply(mockOpen[2], true)
+		Expect(p.ApplyChanges(ctx, changes)).To(MatchError(errRouter))
+	})
+
+	It("should mark records inconsistent between routers as partial", func() {
+		other := openwrt.DNSRecord{Type: "CNAME", CName: "b.foobar.com", Target: "a.foobar.com", Owner: defaultOwnerID, Section: "y"}
+		changed := other
+		changed.Target = "c.foobar.com"
+
+		mockOpen[0].EXPECT().GetDNSRecords(ctx).Return(map[string]openwrt.DNSRecord{"x": record, "y": other}, nil)
+		mockOpen[1].EXPECT().GetDNSRecords(ctx).Return(map[string]openwrt.DNSRecord{"z": record, "y": changed}, nil)
+		mockOpen[2].EXPECT().GetDNSRecords(ctx).Return(map[string]openwrt.DNSRecord{"x": record}, nil)
+
+		endpoints, err := p.Records(ctx)
+		Expect(err).To(BeNil())
+		Expect(endpoints).To(HaveLen(2))
+		Expect(endpoints[0].DNSName).To(Equal("a.foobar.com"))
+		Expect(isPartial(endpoints[0])).To(BeFalse())
+		Expect(endpoints[1].DNSName).To(Equal("b.foobar.com"))
+		Expect(endpoints[1].Targets).To(Equal(endpoint.Targets{"a.foobar.com"}))
+		Expect(isPartial(endpoints[1])).To(BeTrue())
+		Expect(testutil.ToFloat64(inconsistentRecords)).To(Equal(1.0))
+	})
+
+	It("should mark records whose targets differ between routers as partial", func() {
+		second := record
+		second.IP, second.Section = "2.2.2.2", "w"
+
+		mockOpen[0].EXPECT().GetDNSRecords(ctx).Return(map[string]openwrt.DNSRecord{"x": record, "w": second}, nil)
+		mockOpen[1].EXPECT().GetDNSRecords(ctx).Return(map[string]openwrt.DNSRecord{"w": second, "x": record}, nil)
+		mockOpen[2].EXPECT().GetDNSRecords(ctx).Return(map[string]openwrt.DNSRecord{"x": record}, nil)
+
+		endpoints, err := p.Records(ctx)
+		Expect(err).To(BeNil())
+		Expect(endpoints).To(HaveLen(1))
+		Expect(endpoints[0].Targets).To(Equal(endpoint.Targets{record.IP, "2.2.2.2"}))
+		Expect(isPartial(endpoints[0])).To(BeTrue())
+	})
+
+	It("should leave out partial records owned by someone else", func() {
+		other := openwrt.DNSRecord{Type: "CNAME", CName: "b.foobar.com", Target: "a.foobar.com", Section: "y"}
+
+		mockOpen[0].EXPECT().GetDNSRecords(ctx).Return(map[string]openwrt.DNSRecord{"x": record, "y": other}, nil)
+		mockOpen[1].EXPECT().GetDNSRecords(ctx).Return(map[string]openwrt.DNSRecord{}, nil)
+		mockOpen[2].EXPECT().GetDNSRecords(ctx).Return(map[string]openwrt.DNSRecord{}, nil)
+
+		endpoints, err := p.Records(ctx)
+		Expect(err).To(BeNil())
+		Expect(endpoints).To(HaveLen(1))
+		Expect(endpoints[0].DNSName).To(Equal("a.foobar.com"))
+		Expect(isPartial(endpoints[0])).To(BeTrue())
+	})
+
+	It("should leave out static leases owned by someone else", func() {
+		lease := openwrt.DNSRecord{Type: "A", Name: "node.foobar.com", IP: "192.168.1.10", MAC: "aa:bb:cc:dd:ee:ff", Section: "w"}
+		owned := lease
+		owned.Name, owned.Owner, owned.Section = "owned.foobar.com", defaultOwnerID, "v"
+		for _, mockOpenWRT := range mockOpen {
+			mockOpenWRT.EXPECT().GetDNSRecords(ctx).Return(map[string]openwrt.DNSRecord{"w": lease, "v": owned, "x": record}, nil)
+		}
+
+		endpoints, err := p.Records(ctx)
+		Expect(err).To(BeNil())
+		Expect(endpoints).To(HaveLen(2))
+		Expect(endpoints[0].DNSName).To(Equal("a.foobar.com"))
+		Expect(endpoints[1].DNSName).To(Equal("owned.foobar.com"))
+	})
+
+	It("should delete a partial record from the routers holding it", func() {
+		partial := endpoint.NewEndpoint("a.foobar.com", endpoint.RecordTypeA, "1.1.1.1").WithProviderSpecific(providerSpecificPartial, "true")
+		gomock.InOrder(
+			mockOpen[0].EXPECT().GetDNSRecords(ctx).Return(map[string]openwrt.DNSRecord{"x": record}, nil),
+			mockOpen[0].EXPECT().DeleteDNSRecord(ctx, record).Return(nil),
+			mockOpen[0].EXPECT().Commit(ctx).Return(nil),
+			mockOpen[0].EXPECT().Reload(ctx).Return(nil),
+		)
+		mockOpen[1].EXPECT().GetDNSRecords(ctx).Return(map[string]openwrt.DNSRecord{}, nil)
+		mockOpen[2].EXPECT().GetDNSRecords(ctx).Return(map[string]openwrt.DNSRecord{}, nil)
+
+		Expect(p.ApplyChanges(ctx, &plan.Changes{Delete: []*endpoint.Endpoint{partial}})).To(Succeed())
+	})
+
+	It("should write an updated partial record again on every router", func() {
+		changed := record
+		changed.IP = "2.2.2.2"
+		partial := endpoint.NewEndpoint("a.foobar.com", endpoint.RecordTypeA, "1.1.1.1").WithProviderSpecific(providerSpecificPartial, "true")
+		desired := endpoint.NewEndpoint("a.foobar.com", endpoint.RecordTypeA, "1.1.1.1")
+		repaired := openwrt.DNSRecord{Type: "A", Name: "a.foobar.com", IP: "1.1.1.1", Owner: defaultOwnerID}
+
+		mockOpen[0].EXPECT().GetDNSRecords(ctx).Return(map[string]openwrt.DNSRecord{"x": record}, nil)
+		gomock.InOrder(
+			mockOpen[1].EXPECT().GetDNSRecords(ctx).Return(map[string]openwrt.DNSRecord{"x": changed}, nil),
+			mockOpen[1].EXPECT().UpdateDNSRecord(ctx, changed, repaired).Return(nil),
+			mockOpen[1].EXPECT().Commit(ctx).Return(nil),
+			mockOpen[1].EXPECT().Reload(ctx).Return(nil),
+		)
+		gomock.InOrder(
+			mockOpen[2].EXPECT().GetDNSRecords(ctx).Return(map[string]openwrt.DNSRecord{}, nil),
+			mockOpen[2].EXPECT().AddDNSRecord(ctx, repaired).Return(nil),
+			mockOpen[2].EXPECT().Commit(ctx).Return(nil),
+			mockOpen[2].EXPECT().Reload(ctx).Return(nil),
+		)
+
+		Expect(p.ApplyChanges(ctx, &plan.Changes{
+			UpdateOld: []*endpoint.Endpoint{partial},
+			UpdateNew: []*endpoint.Endpoint{desired},
+		})).To(Succeed())
+	})
+
+	It("should leave failed routers out of the records on best effort", func() {
+		p.config.FailurePolicy = FailurePolicyBestEffort
+		mockOpen[0].EXPECT().GetDNSRecords(ctx).Return(nil, errRouter)
+		mockOpen[1].EXPECT().GetDNSRecords(ctx).Return(map[string]openwrt.DNSRecord{"x": record}, nil)
+		mockOpen[2].EXPECT().GetDNSRecords(ctx).Return(map[string]openwrt.DNSRecord{"x": record}, nil)
+
+		endpoints, err := p.Records(ctx)
+		Expect(err).To(BeNil())
+		Expect(endpoints).To(HaveLen(1))
+	})
+
+	Context("domains", func() {
+		BeforeEach(func() {
+			p.routers[0].domains = endpoint.NewDomainFilter([]string{"home.lan"})
+			p.routers[1].domains = endpoint.NewDomainFilter([]string{"parents.lan"})
+			p.routers[2].domains = endpoint.NewDomainFilter([]string{"home.lan", "parents.lan"})
+		})
+
+		It("should split the changes by domain", func() {
+			home := endpoint.NewEndpoint("a.home.lan", endpoint.RecordTypeA, "1.1.1.1")
+			parents := endpoint.NewEndpoint("*.apps.parents.lan", endpoint.RecordTypeA, "2.2.2.2")
+
+			for i, created := range [][]string{{"a.home.lan"}, {"*.apps.parents.lan"}, {"a.home.lan", "*.apps.parents.lan"}} {
+				var names []string
+				gomock.InOrder(
+					mockOpen[i].EXPECT().GetDNSRecords(ctx).Return(map[string]openwrt.DNSRecord{}, nil),
+					mockOpen[i].EXPECT().AddDNSRecord(ctx, gomock.Any()).DoAndReturn(func(_ context.Context, record openwrt.DNSRecord) error {
+						names = append(names, record.Name)
+						return nil
+					}).Times(len(created)),
+					mockOpen[i].EXPECT().Commit(ctx).Return(nil),
+					mockOpen[i].EXPECT().Reload(ctx).DoAndReturn(func(context.Context) error {
+						Expect(names).To(ConsistOf(created))
+						return nil
+					}),
+				)
+			}
+
+			Expect(p.ApplyChanges(ctx, &plan.Changes{Create: []*endpoint.Endpoint{home, parents}})).To(Succeed())
+		})
+
+		It("should leave routers without changes out", func() {
+			expectApply(mockOpen[0], false)
+			expectApply(mockOpen[2], false)
+
+			Expect(p.ApplyChanges(ctx, &plan.Changes{
+				Create: []*endpoint.Endpoint{endpoint.NewEndpoint("a.home.lan", endpoint.RecordTypeA, "1.1.1.1")},
+			})).To(Succeed())
+		})
+
+		It("should compare records with the routers of their domain", func() {
+			home := openwrt.DNSRecord{Type: "A", Name: "a.home.lan", IP: "1.1.1.1"}
+			parents := openwrt.DNSRecord{Type: "A", Name: "a.parents.lan", IP: "2.2.2.2"}
+			other := openwrt.DNSRecord{Type: "A", Name: "router.lan", IP: "3.3.3.3"}
+
+			mockOpen[0].EXPECT().GetDNSRecords(ctx).Return(map[string]openwrt.DNSRecord{"x": home, "z": other}, nil)
+			mockOpen[1].EXPECT().GetDNSRecords(ctx).Return(map[string]openwrt.DNSRecord{"y": parents}, nil)
+			mockOpen[2].EXPECT().GetDNSRecords(ctx).Return(map[string]openwrt.DNSRecord{"x": home, "y": parents}, nil)
+
+			endpoints, err := p.Records(ctx)
+			Expect(err).To(BeNil())
+			Expect(endpoints).To(HaveLen(2))
+			Expect(endpoints[0].DNSName).To(Equal("a.home.lan"))
+			Expect(endpoints[1].DNSName).To(Equal("a.parents.lan"))
+		})
+
+		It("should advertise the domains of the routers", func() {
+			filter := p.GetDomainFilter()
+			Expect(filter.Match("a.home.lan")).To(BeTrue())
+			Expect(filter.Match("a.parents.lan")).To(BeTrue())
+			Expect(filter.Match("a.other.lan")).To(BeFalse())
+
+			p.routers[2].domains = endpoint.DomainFilter{}
+			Expect(p.GetDomainFilter().Match("a.other.lan")).To(BeTrue())
+		})
+	})
+
+	Context("config", func() {
+		It("should inherit the openwrt settings", func() {
+			config := DefaultConfig()
+			config.OpenWRT.Backend = openwrt.BackendUnbound
+			config.OpenWRT.LuciRPC.Auth.Username = "root"
+			config.Routers = []RouterConfig{
+				{Name: "primary"},
+				{Name: "secondary", OpenWRT: map[string]any{
+					"lucirpc": map[string]any{"hostname": "192.168.1.2", "auth": map[string]any{"password": "secret"}},
+				}},
+			}
+
+			names, configs, err := config.routerConfigs()
+			Expect(err).To(BeNil())
+			Expect(names).To(Equal([]string{"primary", "secondary"}))
+			Expect(configs[1].Backend).To(Equal(openwrt.BackendUnbound))
+			Expect(configs[1].LuciRPC.Hostname).To(Equal("192.168.1.2"))
+			Expect(configs[1].LuciRPC.Auth.Username).To(Equal("root"))
+			Expect(configs[1].LuciRPC.Auth.Password).To(Equal("secret"))
+			Expect(configs[0].LuciRPC.Auth.Password).To(BeEmpty())
+		})
+
+		It("should reject unknown settings", func() {
+			config := DefaultConfig()
+			config.Routers = []RouterConfig{{Name: "primary", OpenWRT: map[string]any{"hostname": "192.168.1.2"}}}
+
+			_, _, err := config.routerConfigs()
+			Expect(err).To(MatchError(ContainSubstring("invalid router primary")))
+		})
+
+		It("should not migrate host records unless every router uses dnsmasq", func() {
+			config := DefaultConfig()
+			config.Routers = []RouterConfig{
+				{Name: "primary"},
+				{Name: "secondary", OpenWRT: map[string]any{"backend": openwrt.BackendHosts}},
+			}
+
+			count, skipped, err := MigrateHostRecords(ctx, config)
+			Expect(err).To(MatchError("router secondary: host records are not supported by the hosts backend"))
+			Expect(count).To(BeZero())
+			Expect(skipped).To(BeZero())
+		})
+
+		It("should reject duplicate routers", func() {
+			config := DefaultConfig()
+			config.Routers = []RouterConfig{{Name: "primary"}, {Name: "primary"}}
+			Expect(config.validate()).To(MatchError("duplicate router: primary"))
+		})
+	})
+})
@@ -0,0 +1,94 @@
+package provider
+
+import (
+	"sync"
+	"time"
+
+	"github.com/renanqts/external-dns-openwrt-webhook/pkg/logger"
+	"go.uber.org/zap"
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+const (
+	holdReasonNotOwned     = "not_owned"
+	holdReasonGracePeriod  = "grace_period"
+	holdReasonMaxDeletions = "max_deletions"
+)
+
+// deleteGuard holds the deletes of the sync policy, so a source briefly returning
+// no endpoints does not wipe the records of the routers
+type deleteGuard struct {
+	config *SyncConfig
+	now    func() time.Time
+
+	mu sync.Mutex
+	// requested holds when each record was first and last requested to be deleted
+	requested map[string]deleteRequest
+}
+
+type deleteRequest struct {
+	first, last time.Time
+}
+
+func newDeleteGuard(config *SyncConfig) *deleteGuard {
+	return &deleteGuard{
+		config:    config,
+		now:       time.Now,
+		requested: make(map[string]deleteRequest),
+	}
+}
+
+// filter returns the deletes requested for the grace period. A request is kept while
+// every sync repeats it, whatever the sync interval, and starts over when a sync leaves
+// it out or when it is not repeated within the forget window. Every delete is held when
+// there are more than the max deletions.
+func (g *deleteGuard) filter(deletes []*endpoint.Endpoint) []*endpoint.Endpoint {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.now()
+	gracePeriod := time.Duration(g.config.GracePeriod) * time.Second
+	forgetAfter := time.Duration(g.config.ForgetAfter) * time.Second
+	requested := make(map[string]deleteRequest, len(deletes))
+	var due []*endpoint.Endpoint
+	for _, ep := range deletes {
+		key := ep.RecordType + " " + ep.DNSName
+		request, ok := g.requested[key]
+		if !ok || (forgetAfter > 0 && now.Sub(request.last) > forgetAfter) {
+			request.first = now
+		}
+		request.last = now
+		requested[key] = request
+
+		if now.Sub(request.first) < gracePeriod {
+			logger.Log.Info("holding delete during the grace period", zap.String("record", key),
+				zap.Time("since", request.first))
+			heldDeletions.WithLabelValues(holdReasonGracePeriod).Inc()
+			continue
+		}
+		due = append(due, ep)
+	}
+	// requests which are not repeated are forgotten
+	g.requested = requested
+
+	if g.config.MaxDeletions > 0 && len(due) > g.config.MaxDeletions {
+		logger.Log.Error("holding every delete, too many records to delete", zap.Int("deletes", len(due)),
+			zap.Int("max", g.config.MaxDeletions))
+		heldDeletions.WithLabelValues(holdReasonMaxDeletions).Add(float64(len(due)))
+		return nil
+	}
+
+	return due
+}
+
+// holdDeletes returns the changes without the deletes held by the guard
+func (p *Provider) holdDeletes(changes *plan.Changes) *plan.Changes {
+	if p.deletes == nil {
+		return changes
+	}
+
+	guarded := *changes
+	guarded.Delete = p.deletes.filter(changes.Delete)
+	return &guarded
+}
@@ -0,0 +1,136 @@
+package provider
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/renanqts/external-dns-openwrt-webhook/pkg/openwrt"
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+var _ = Describe("Sync", func() {
+	var (
+		guard *deleteGuard
+		now   time.Time
+
+		a = endpoint.NewEndpoint("a.foobar.com", endpoint.RecordTypeA, "1.1.1.1")
+		b = endpoint.NewEndpoint("b.foobar.com", endpoint.RecordTypeA, "2.2.2.2")
+	)
+
+	held := func(reason string) float64 {
+		return testutil.ToFloat64(heldDeletions.WithLabelValues(reason))
+	}
+
+	BeforeEach(func() {
+		guard = newDeleteGuard(&SyncConfig{})
+		now = time.Now()
+		guard.now = func() time.Time { return now }
+	})
+
+	It("should pass deletes through by default", func() {
+		Expect(guard.filter([]*endpoint.Endpoint{a, b})).To(Equal([]*endpoint.Endpoint{a, b}))
+	})
+
+	It("should hold deletes during the grace period", func() {
+		guard.config.GracePeriod = 60
+		count := held(holdReasonGracePeriod)
+
+		Expect(guard.filter([]*endpoint.Endpoint{a})).To(BeEmpty())
+		now = now.Add(30 * time.Second)
+		Expect(guard.filter([]*endpoint.Endpoint{a, b})).To(BeEmpty())
+		now = now.Add(30 * time.Second)
+		Expect(guard.filter([]*endpoint.Endpoint{a, b})).To(Equal([]*endpoint.Endpoint{a}))
+		Expect(held(holdReasonGracePeriod)).To(Equal(count + 4))
+	})
+
+	It("should keep the first request across syncs longer than the grace period", func() {
+		guard.config.GracePeriod = 30
+
+		Expect(guard.filter([]*endpoint.Endpoint{a})).To(BeEmpty())
+		now = now.Add(60 * time.Second)
+		Expect(guard.filter([]*endpoint.Endpoint{a})).To(Equal([]*endpoint.Endpoint{a}))
+		now = now.Add(60 * time.Second)
+		Expect(guard.filter([]*endpoint.Endpoint{a})).To(Equal([]*endpoint.Endpoint{a}))
+	})
+
+	It("should start over requests not repeated within the forget window", func() {
+		guard.config.GracePeriod = 30
+		guard.config.ForgetAfter = 120
+
+		Expect(guard.filter([]*endpoint.Endpoint{a})).To(BeEmpty())
+		now = now.Add(180 * time.Second)
+		Expect(guard.filter([]*endpoint.Endpoint{a})).To(BeEmpty())
+		now = now.Add(60 * time.Second)
+		Expect(guard.filter([]*endpoint.Endpoint{a})).To(Equal([]*endpoint.Endpoint{a}))
+	})
+
+	It("should start over deletes which are not requested anymore", func() {
+		guard.config.GracePeriod = 60
+
+		Expect(guard.filter([]*endpoint.Endpoint{a})).To(BeEmpty())
+		now = now.Add(30 * time.Second)
+		Expect(guard.filter(nil)).To(BeEmpty())
+		now = now.Add(30 * time.Second)
+		Expect(guard.filter([]*endpoint.Endpoint{a})).To(BeEmpty())
+	})
+
+	It("should hold every delete above the max deletions", func() {
+		guard.config.MaxDeletions = 1
+		count := held(holdReasonMaxDeletions)
+
+		Expect(guard.filter([]*endpoint.Endpoint{a, b})).To(BeEmpty())
+		Expect(held(holdReasonMaxDeletions)).To(Equal(count + 2))
+		Expect(guard.filter([]*endpoint.Endpoint{a})).To(Equal([]*endpoint.Endpoint{a}))
+	})
+
+	It("should only hold the deletes of the changes", func() {
+		guard.config.MaxDeletions = 1
+		p := &Provider{deletes: guard}
+		changes := &plan.Changes{
+			Create: []*endpoint.Endpoint{a},
+			Delete: []*endpoint.Endpoint{a, b},
+		}
+
+		guarded := p.holdDeletes(changes)
+		Expect(guarded.Create).To(Equal(changes.Create))
+		Expect(guarded.Delete).To(BeEmpty())
+		Expect(changes.Delete).To(HaveLen(2))
+	})
+
+	It("should only delete owned records by default", func() {
+		owner := DefaultConfig().Sync.OwnerID
+		Expect(owner).To(Equal(defaultOwnerID))
+
+		operations, err := reconcile(map[string]openwrt.DNSRecord{
+			"x": {Type: "A", Name: "a.foobar.com", IP: "1.1.1.1", Section: "x"},
+			"y": {Type: "A", Name: "*.foobar.com", IP: "1.1.1.1", Owner: owner, Section: "y"},
+		}, &plan.Changes{Delete: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("a.foobar.com", endpoint.RecordTypeA, "1.1.1.1"),
+			endpoint.NewEndpoint("*.foobar.com", endpoint.RecordTypeA, "1.1.1.1"),
+		}}, MissingRecordPolicyError, owner)
+		Expect(err).To(BeNil())
+		Expect(operations).To(HaveLen(1))
+		Expect(operations[0].Current.Section).To(Equal("y"))
+	})
+
+	It("should return the owner of records", func() {
+		endpoints := dnsRecords2Endpoints(map[string]openwrt.DNSRecord{
+			"x": {Type: "A", Name: "a.foobar.com", IP: "1.1.1.1", Owner: "external-dns"},
+		}, defaultTTL)
+		Expect(endpoints).To(HaveLen(1))
+		Expect(endpoints[0].Labels).To(HaveKeyWithValue(endpoint.OwnerLabelKey, "external-dns"))
+	})
+
+	It("should reject invalid owners", func() {
+		config := DefaultConfig()
+		config.Sync.OwnerID = "external dns"
+		Expect(config.validate()).To(MatchError(ContainSubstring("invalid owner id")))
+
+		config = DefaultConfig()
+		config.Sync.GracePeriod = -1
+		Expect(config.validate()).To(MatchError(ContainSubstring("cannot be negative")))
+	})
+})
@@ -0,0 +1,193 @@
+package provider
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+// addressRule translates the addresses of from to the same host of to
+type addressRule struct {
+	from, to netip.Prefix
+}
+
+func (r addressRule) apply(addr netip.Addr) (netip.Addr, bool) {
+	if !r.from.Contains(addr) {
+		return addr, false
+	}
+
+	host, network := addr.AsSlice(), r.to.Addr().AsSlice()
+	for bit := 0; bit < r.to.Bits(); bit++ {
+		mask := byte(0x80 >> (bit % 8))
+		host[bit/8] = host[bit/8]&^mask | network[bit/8]&mask
+	}
+
+	translated, _ := netip.AddrFromSlice(host)
+	return translated, true
+}
+
+// addressTranslator translates addresses with the matching rule, addresses
+// read from the routers with the matching reverse rule. The prefixes of the
+// rules do not overlap, so at most one of them matches.
+type addressTranslator struct {
+	rules, reverse []addressRule
+}
+
+func newAddressTranslator(rules []TranslateRule) (*addressTranslator, error) {
+	translator := &addressTranslator{}
+	for i, rule := range rules {
+		forward, err := rule.compile()
+		if err != nil {
+			return nil, fmt.Errorf("invalid translate rule %d: %w", i, err)
+		}
+
+		// overlapping prefixes would translate two addresses to the same one
+		for j, other := range translator.rules {
+			if forward.from.Overlaps(other.from) {
+				return nil, fmt.Errorf("invalid translate rule %d: from %s overlaps rule %d", i, rule.From, j)
+			}
+			if forward.to.Overlaps(other.to) {
+				return nil, fmt.Errorf("invalid translate rule %d: to %s overlaps rule %d", i, rule.To, j)
+			}
+		}
+
+		translator.rules = append(translator.rules, forward)
+		translator.reverse = append(translator.reverse, addressRule{from: forward.to, to: forward.from})
+	}
+
+	return translator, nil
+}
+
+func (r TranslateRule) compile() (addressRule, error) {
+	from, err := parsePrefix(r.From)
+	if err != nil {
+		return addressRule{}, fmt.Errorf("invalid from: %w", err)
+	}
+
+	to, err := parsePrefix(r.To)
+	if err != nil {
+		return addressRule{}, fmt.Errorf("invalid to: %w", err)
+	}
+
+	switch {
+	case from.Addr().Is4() != to.Addr().Is4():
+		return addressRule{}, fmt.Errorf("from %s and to %s are not of the same address family", r.From, r.To)
+	case from.Bits() != to.Bits():
+		return addressRule{}, fmt.Errorf("from %s and to %s do not have the same prefix length", r.From, r.To)
+	}
+
+	return addressRule{from: from, to: to}, nil
+}
+
+// parsePrefix parses a prefix, or an address as the prefix of its own
+func parsePrefix(s string) (netip.Prefix, error) {
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		if addr.Zone() != "" {
+			return netip.Prefix{}, fmt.Errorf("zoned address %s", s)
+		}
+
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	return prefix.Masked(), nil
+}
+
+// write returns the address stored on the routers, it fails when the address
+// would not be read back as is, e.g. an address of the translated prefixes
+// which is not translated itself. Targets which are not addresses are returned as is.
+func (t *addressTranslator) write(target string) (string, error) {
+	if t == nil || len(t.rules) == 0 {
+		return target, nil
+	}
+
+	addr, err := netip.ParseAddr(target)
+	if err != nil {
+		return target, nil
+	}
+
+	written := translate(t.rules, addr)
+	if read := translate(t.reverse, written); read != addr {
+		return "", fmt.Errorf("translating %s to %s does not round-trip, it is read back as %s", target, written, read)
+	}
+
+	return written.String(), nil
+}
+
+// read returns the address of a record read from the routers
+func (t *addressTranslator) read(target string) string {
+	if t == nil || len(t.reverse) == 0 {
+		return target
+	}
+
+	addr, err := netip.ParseAddr(target)
+	if err != nil {
+		return target
+	}
+
+	if read := translate(t.reverse, addr); read != addr {
+		return read.String()
+	}
+
+	return target
+}
+
+func translate(rules []addressRule, addr netip.Addr) netip.Addr {
+	for _, rule := range rules {
+		if translated, ok := rule.apply(addr); ok {
+			return translated
+		}
+	}
+
+	return addr
+}
+
+// translateChanges returns a copy of the changes with the targets of the A and AAAA
+// endpoints translated, it fails on any target which cannot be translated
+func translateChanges(changes *plan.Changes, translate func(string) (string, error)) (*plan.Changes, error) {
+	return mapChanges(changes, func(ep *endpoint.Endpoint) (*endpoint.Endpoint, error) {
+		if ep.RecordType != endpoint.RecordTypeA && ep.RecordType != endpoint.RecordTypeAAAA {
+			return ep, nil
+		}
+
+		targets := make(endpoint.Targets, len(ep.Targets))
+		changed := false
+		for i, target := range ep.Targets {
+			var err error
+			if targets[i], err = translate(target); err != nil {
+				return nil, fmt.Errorf("%s %s: %w", ep.RecordType, ep.DNSName, err)
+			}
+			changed = changed || targets[i] != target
+		}
+
+		if !changed {
+			return ep, nil
+		}
+
+		ep = ep.DeepCopy()
+		ep.Targets = targets
+		return ep, nil
+	})
+}
+
+// translateEndpoints translates the targets of the A and AAAA endpoints in place
+func translateEndpoints(endpoints []*endpoint.Endpoint, translate func(string) string) {
+	for _, ep := range endpoints {
+		if ep.RecordType == endpoint.RecordTypeA || ep.RecordType == endpoint.RecordTypeAAAA {
+			for i, target := range ep.Targets {
+				ep.Targets[i] = translate(target)
+			}
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package provider
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	mocks "github.com/renanqts/external-dns-openwrt-webhook/internal/mocks/openwrt"
+	"github.com/renanqts/external-dns-openwrt-webhook/pkg/openwrt"
+	"go.uber.org/mock/gomock"
+	"sigs.k8s.io/external-dns/endpoint"
+	"sigs.k8s.io/external-dns/plan"
+)
+
+var _ = Describe("Address translation", func() {
+	var (
+		ctx         context.Context
+		mockCtrl    *gomock.Controller
+		mockOpenWRT *mocks.MockOpenWRT
+		p           *Provider
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockOpenWRT = mocks.NewMockOpenWRT(mockCtrl)
+		config := DefaultConfig()
+		config.Sync.OwnerID = ""
+		config.Translate = []TranslateRule{
+			{From: "203.0.113.0/24", To: "192.168.10.0/24"},
+			{From: "198.51.100.7", To: "192.168.20.1"},
+			{From: "2001:db8:1::/64", To: "fd00:10::/64"},
+		}
+		translator, err := newAddressTranslator(config.Translate)
+		Expect(err).To(BeNil())
+		p = &Provider{
+			config:     config,
+			routers:    []*router{newRouter("primary", mockOpenWRT, config.Cache)},
+			translator: translator,
+		}
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	It("should translate addresses keeping their host and back", func() {
+		for addr, written := range map[string]string{
+			"203.0.113.42":     "192.168.10.42",
+			"198.51.100.7":     "192.168.20.1",
+			"2001:db8:1::abcd": "fd00:10::abcd",
+			"1.1.1.1":          "1.1.1.1",
+			"a.home.lan":       "a.home.lan",
+		} {
+			translated, err := p.translator.write(addr)
+			Expect(err).To(BeNil())
+			Expect(translated).To(Equal(written))
+			Expect(p.translator.read(translated)).To(Equal(addr))
+		}
+	})
+
+	It("should reject addresses which do not round-trip", func() {
+		_, err := p.translator.write("192.168.10.42")
+		Expect(err).To(MatchError("translating 192.168.10.42 to 192.168.10.42 does not round-trip, it is read back as 203.0.113.42"))
+	})
+
+	It("should write the translated addresses", func() {
+		gomock.InOrder(
+			mockOpenWRT.EXPECT().GetDNSRecords(ctx).Return(map[string]openwrt.DNSRecord{}, nil),
+			mockOpenWRT.EXPECT().AddDNSRecord(ctx, openwrt.DNSRecord{Type: "A", Name: "a.home.lan", IP: "192.168.10.42"}).Return(nil),
+			mockOpenWRT.EXPECT().AddDNSRecord(ctx, openwrt.DNSRecord{Type: "CNAME", CName: "b.home.lan", Target: "a.home.lan"}).Return(nil),
+			mockOpenWRT.EXPECT().Commit(ctx).Return(nil),
+			mockOpenWRT.EXPECT().Reload(ctx).Return(nil),
+		)
+
+		changes := &plan.Changes{Create: []*endpoint.Endpoint{
+			endpoint.NewEndpoint("a.home.lan", endpoint.RecordTypeA, "203.0.113.42"),
+			endpoint.NewEndpoint("b.home.lan", endpoint.RecordTypeCNAME, "a.home.lan"),
+		}}
+		Expect(p.ApplyChanges(ctx, changes)).To(Succeed())
+		Expect(changes.Create[0].Targets).To(Equal(endpoint.Targets{"203.0.113.42"}))
+	})
+
+	It("should reject changes which do not round-trip before reading the routers", func() {
+		Expect(p.ApplyChanges(ctx, &plan.Changes{
+			Create: []*endpoint.Endpoint{endpoint.NewEndpoint("a.home.lan", endpoint.RecordTypeA, "192.168.10.42")},
+		})).To(MatchError(ContainSubstring("A a.home.lan: translating 192.168.10.42")))
+	})
+
+	It("should return the addresses translated back", func() {
+		mockOpenWRT.EXPECT().GetDNSRecords(ctx).Return(map[string]openwrt.DNSRecord{
+			"x": {Type: "A", Name: "a.home.lan", IP: "192.168.10.42", Section: "x"},
+			"y": {Type: "AAAA", Name: "a.home.lan", IP: "fd00:10::abcd", Section: "y"},
+		}, nil)
+
+		endpoints, err := p.Records(ctx)
+		Expect(err).To(BeNil())
+		Expect(endpoints).To(HaveLen(2))
+		Expect(endpoints[0].Targets).To(Equal(endpoint.Targets{"203.0.113.42"}))
+		Expect(endpoints[1].Targets).To(Equal(endpoint.Targets{"2001:db8:1::abcd"}))
+	})
+
+	It("should reject rules which are not bijective", func() {
+		config := DefaultConfig()
+		for message, rules := range map[string][]TranslateRule{
+			"invalid translate rule 0: invalid from":                       {{From: "203.0.113.0/33", To: "192.168.10.0/24"}},
+			"invalid translate rule 0: invalid to":                         {{From: "203.0.113.1", To: "fe80::1%eth0"}},
+			"not of the same address family":                               {{From: "203.0.113.1", To: "fd00::1"}},
+			"do not have the same prefix length":                           {{From: "203.0.113.0/24", To: "192.168.0.0/16"}},
+			"invalid translate rule 1: from 203.0.113.7 overlaps rule 0":   {{From: "203.0.113.0/24", To: "192.168.10.0/24"}, {From: "203.0.113.7", To: "192.168.20.1"}},
+			"invalid translate rule 1: to 192.168.10.0/25 overlaps rule 0": {{From: "203.0.113.0/24", To: "192.168.10.0/24"}, {From: "198.51.100.0/25", To: "192.168.10.0/25"}},
+		} {
+			config.Translate = rules
+			Expect(config.validate()).To(MatchError(ContainSubstring(message)))
+		}
+	})
+})
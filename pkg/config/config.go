@@ -2,13 +2,22 @@ package config
 
 import (
 	"fmt"
+	"os"
 	"strings"
 
 	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/viper"
 )
 
+// configFileEnv points to an optional config file, required by settings
+// which cannot be expressed with environment variables like lists of objects
+const configFileEnv = "CONFIG_FILE"
+
 func Read(config any) error {
+	if configFile := os.Getenv(configFileEnv); configFile != "" {
+		viper.SetConfigFile(configFile)
+	}
+
 	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_", "-", "_"))
 	viper.AllowEmptyEnv(true)
 	viper.AutomaticEnv()
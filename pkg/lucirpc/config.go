@@ -6,6 +6,7 @@ const (
 	defaultInsecureSkipVerify = false
 	defaultRpcServerPort      = 443
 	defaultSSL                = true
+	defaultFailbackInterval   = 60
 )
 
 type Auth struct {
@@ -21,6 +22,11 @@ type Config struct {
 	Timeout            int    `mapstructure:"timeout"`
 	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify"`
 	Auth               Auth   `mapstructure:"auth"`
+	// Endpoints are addresses of the same router, host or host:port, tried in order
+	// while the previous ones are unreachable. Hostname is used when it is empty.
+	Endpoints []string `mapstructure:"endpoints"`
+	// FailbackInterval is how long an unreachable endpoint is skipped, in seconds
+	FailbackInterval int `mapstructure:"failback_interval"`
 }
 
 func DefaultConfig() *Config {
@@ -30,5 +36,6 @@ func DefaultConfig() *Config {
 		RpcID:              defaultRpcID,
 		Timeout:            defaultTimeout,
 		InsecureSkipVerify: defaultInsecureSkipVerify,
+		FailbackInterval:   defaultFailbackInterval,
 	}
 }
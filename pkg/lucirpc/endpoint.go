@@ -0,0 +1,147 @@
+package lucirpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/renanqts/external-dns-openwrt-webhook/pkg/logger"
+	"go.uber.org/zap"
+)
+
+// endpoint is an address of the router, failed is zero while it is reachable
+type endpoint struct {
+	address string
+	failed  time.Time
+}
+
+// errStatus wraps errors of requests answered by the router
+type errStatus struct {
+	err error
+}
+
+func (e *errStatus) Error() string { return e.err.Error() }
+func (e *errStatus) Unwrap() error { return e.err }
+
+// errNotSent wraps errors of requests which did not reach the router,
+// e.g. failing to connect, they can be sent to another endpoint
+type errNotSent struct {
+	err error
+}
+
+func (e *errNotSent) Error() string { return e.err.Error() }
+func (e *errNotSent) Unwrap() error { return e.err }
+
+// candidates returns the endpoints in the order they are tried: reachable ones
+// and those failed longer than the failback interval ago by preference, then the
+// recently failed ones as a last resort
+func (c *lucirpc) candidates() []*endpoint {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.endpoints == nil {
+		addresses := c.config.Endpoints
+		if len(addresses) == 0 {
+			addresses = []string{c.config.Hostname}
+		}
+
+		for _, address := range addresses {
+			c.endpoints = append(c.endpoints, &endpoint{address: c.hostPort(address)})
+		}
+	}
+
+	failback := time.Now().Add(-time.Duration(c.config.FailbackInterval) * time.Second)
+	var preferred, failed []*endpoint
+	for _, e := range c.endpoints {
+		if e.failed.IsZero() || e.failed.Before(failback) {
+			preferred = append(preferred, e)
+			continue
+		}
+		failed = append(failed, e)
+	}
+
+	return append(preferred, failed...)
+}
+
+// callEndpoints posts to the first endpoint reaching the router. Only requests which were
+// not sent move to the next endpoint: the router may have run the others already, e.g. an
+// uci add timing out, and running them twice would duplicate their changes.
+// Answers with an error status do not move to the next endpoint either.
+func (c *lucirpc) callEndpoints(ctx context.Context, uri string, postBody []byte) ([]byte, error) {
+	var err error
+	for _, e := range c.candidates() {
+		var respBody []byte
+		respBody, err = c.call(ctx, c.baseUrl(e)+uri, postBody)
+
+		var status *errStatus
+		if err == nil || errors.As(err, &status) {
+			c.reachable(e)
+			if status != nil {
+				return respBody, status.err
+			}
+			return respBody, nil
+		}
+
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		c.unreachable(e, err)
+
+		var notSent *errNotSent
+		if !errors.As(err, &notSent) {
+			return nil, err
+		}
+	}
+
+	return nil, err
+}
+
+func (c *lucirpc) reachable(e *endpoint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !e.failed.IsZero() {
+		logger.Log.Info("endpoint recovered", zap.String("endpoint", e.address))
+		e.failed = time.Time{}
+	}
+
+	if c.active != e {
+		if c.active != nil {
+			logger.Log.Warn("switched endpoint", zap.String("from", c.active.address), zap.String("to", e.address))
+		}
+		c.active = e
+	}
+}
+
+func (c *lucirpc) unreachable(e *endpoint, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	logger.Log.Warn("endpoint unreachable", zap.String("endpoint", e.address), zap.Error(err))
+	e.failed = time.Now()
+}
+
+func (c *lucirpc) baseUrl(e *endpoint) string {
+	proto := "https://"
+	if !c.config.SSL {
+		proto = "http://"
+	}
+
+	return proto + e.address
+}
+
+// hostPort adds the configured port to addresses without one
+func (c *lucirpc) hostPort(address string) string {
+	if _, _, err := net.SplitHostPort(address); err == nil {
+		return address
+	}
+
+	// bracketed IPv6 addresses without a port
+	if len(address) > 1 && address[0] == '[' && address[len(address)-1] == ']' {
+		address = address[1 : len(address)-1]
+	}
+
+	return net.JoinHostPort(address, strconv.Itoa(c.config.Port))
+}
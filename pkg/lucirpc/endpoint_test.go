@@ -0,0 +1,125 @@
+package lucirpc
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Endpoints", func() {
+	var (
+		ctx    context.Context
+		ts     *httptest.Server
+		calls  int
+		status int
+		down   string
+		client *lucirpc
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		calls = 0
+		status = http.StatusOK
+
+		mux := http.NewServeMux()
+		mux.HandleFunc(uciPath, func(w http.ResponseWriter, r *http.Request) {
+			calls++
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(`{"result":"ok"}`))
+		})
+		ts = httptest.NewServer(mux)
+
+		// a port nothing listens on
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).To(BeNil())
+		down = l.Addr().String()
+		Expect(l.Close()).To(Succeed())
+
+		u, err := url.Parse(ts.URL)
+		Expect(err).To(BeNil())
+
+		config := DefaultConfig()
+		config.SSL = false
+		config.Endpoints = []string{down, u.Host}
+		client = &lucirpc{
+			config:     config,
+			httpClient: ts.Client(),
+			token:      "foobar",
+		}
+	})
+
+	AfterEach(func() {
+		ts.Close()
+	})
+
+	It("should fail over to the next endpoint", func() {
+		resp, err := client.Uci(ctx, "get", []string{"network.lan.ipaddr"})
+		Expect(err).To(BeNil())
+		Expect(resp).To(Equal("ok"))
+		Expect(client.active.address).ToNot(Equal(down))
+
+		candidates := client.candidates()
+		Expect(candidates[0].address).ToNot(Equal(down))
+		Expect(candidates[1].address).To(Equal(down))
+	})
+
+	It("should fail back once the failback interval is over", func() {
+		_, err := client.Uci(ctx, "get", []string{"network.lan.ipaddr"})
+		Expect(err).To(BeNil())
+
+		client.endpoints[0].failed = time.Now().Add(-time.Duration(client.config.FailbackInterval+1) * time.Second)
+		Expect(client.candidates()[0].address).To(Equal(down))
+	})
+
+	It("should not fail over on error answers", func() {
+		client.config.Endpoints = []string{client.config.Endpoints[1], down}
+		status = http.StatusInternalServerError
+
+		_, err := client.Uci(ctx, "get", []string{"network.lan.ipaddr"})
+		Expect(err).To(MatchError("http status code: 500"))
+		Expect(calls).To(Equal(1))
+		Expect(client.endpoints[0].failed.IsZero()).To(BeTrue())
+		Expect(client.endpoints[1].failed.IsZero()).To(BeTrue())
+	})
+
+	It("should not send a request again once the router may have run it", func() {
+		// the router reads the request and drops the connection without answering
+		dropped := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			Expect(err).To(BeNil())
+			Expect(conn.Close()).To(Succeed())
+		}))
+		defer dropped.Close()
+
+		u, err := url.Parse(dropped.URL)
+		Expect(err).To(BeNil())
+		client.config.Endpoints = []string{u.Host, client.config.Endpoints[1]}
+
+		_, err = client.Uci(ctx, "add", []string{"dhcp", "domain"})
+		Expect(err).ToNot(BeNil())
+		Expect(calls).To(BeZero())
+		Expect(client.endpoints[0].failed.IsZero()).To(BeFalse())
+	})
+
+	It("should fail when every endpoint is unreachable", func() {
+		client.config.Endpoints = []string{down}
+
+		_, err := client.Uci(ctx, "get", []string{"network.lan.ipaddr"})
+		Expect(err).ToNot(BeNil())
+		Expect(client.endpoints[0].failed.IsZero()).To(BeFalse())
+	})
+
+	It("should add the port to addresses", func() {
+		Expect(client.hostPort("192.168.1.1")).To(Equal("192.168.1.1:443"))
+		Expect(client.hostPort("router.lan:8443")).To(Equal("router.lan:8443"))
+		Expect(client.hostPort("fd00::1")).To(Equal("[fd00::1]:443"))
+		Expect(client.hostPort("[fd00::1]")).To(Equal("[fd00::1]:443"))
+		Expect(client.hostPort("[fd00::1]:8443")).To(Equal("[fd00::1]:8443"))
+	})
+})
@@ -12,7 +12,9 @@ import (
 	"io"
 	"net"
 	"net/http"
-	"strconv"
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/renanqts/external-dns-openwrt-webhook/pkg/logger"
@@ -23,6 +25,8 @@ const (
 	rpcPath  = "/cgi-bin/luci/rpc/"
 	authPath = rpcPath + "auth"
 	uciPath  = rpcPath + "uci"
+	sysPath  = rpcPath + "sys"
+	fsPath   = rpcPath + "fs"
 
 	methodLogin = "login"
 )
@@ -37,12 +41,17 @@ var (
 
 type LuciRPC interface {
 	Uci(context.Context, string, []string) (string, error)
+	// UciList calls a uci method passing a list as the last parameter
+	UciList(context.Context, string, []string, []string) (string, error)
+	Sys(context.Context, string, []string) (string, error)
+	// Fs calls a file method, file contents are base64 encoded
+	Fs(context.Context, string, []string) (string, error)
 }
 
 type Payload struct {
-	ID     int      `json:"id"`
-	Method string   `json:"method"`
-	Params []string `json:"params"`
+	ID     int    `json:"id"`
+	Method string `json:"method"`
+	Params []any  `json:"params"`
 }
 
 type Response struct {
@@ -55,6 +64,10 @@ type lucirpc struct {
 	config     *Config
 	token      string
 	httpClient *http.Client
+
+	mu        sync.Mutex
+	endpoints []*endpoint
+	active    *endpoint
 }
 
 func New(config *Config) (LuciRPC, error) {
@@ -77,11 +90,23 @@ func New(config *Config) (LuciRPC, error) {
 }
 
 func (c *lucirpc) Uci(ctx context.Context, method string, params []string) (string, error) {
-	return c.rpcWithAuth(ctx, uciPath, method, params)
+	return c.rpcWithAuth(ctx, uciPath, method, toParams(params))
+}
+
+func (c *lucirpc) UciList(ctx context.Context, method string, params []string, list []string) (string, error) {
+	return c.rpcWithAuth(ctx, uciPath, method, append(toParams(params), list))
+}
+
+func (c *lucirpc) Sys(ctx context.Context, method string, params []string) (string, error) {
+	return c.rpcWithAuth(ctx, sysPath, method, toParams(params))
+}
+
+func (c *lucirpc) Fs(ctx context.Context, method string, params []string) (string, error) {
+	return c.rpcWithAuth(ctx, fsPath, method, toParams(params))
 }
 
 func (c *lucirpc) auth(ctx context.Context) error {
-	token, err := c.rpc(ctx, authPath, methodLogin, []string{c.config.Auth.Username, c.config.Auth.Password})
+	token, err := c.rpc(ctx, authPath, methodLogin, []any{c.config.Auth.Username, c.config.Auth.Password})
 	if err != nil {
 		logger.Log.Error("rpc: login fail", zap.Error(err))
 		return err
@@ -97,7 +122,7 @@ func (c *lucirpc) auth(ctx context.Context) error {
 	return nil
 }
 
-func (c *lucirpc) rpc(ctx context.Context, path, method string, params []string) (string, error) {
+func (c *lucirpc) rpc(ctx context.Context, path, method string, params []any) (string, error) {
 	data, err := json.Marshal(Payload{
 		ID:     c.config.RpcID,
 		Method: method,
@@ -108,8 +133,7 @@ func (c *lucirpc) rpc(ctx context.Context, path, method string, params []string)
 		return "", err
 	}
 
-	url := c.getUri(path, method)
-	respBody, err := c.call(ctx, url, data)
+	respBody, err := c.callEndpoints(ctx, c.getUri(path, method), data)
 	if err != nil {
 		logger.Log.Error("call fail", zap.Error(err))
 		return "", err
@@ -132,23 +156,25 @@ func (c *lucirpc) rpc(ctx context.Context, path, method string, params []string)
 	return "", nil
 }
 
+// getUri returns the request uri, the endpoint is chosen by callEndpoints
 func (c *lucirpc) getUri(path, method string) string {
 	logger.Log.Debug("uri", zap.String("path", path), zap.String("method", method), zap.String("token", c.token))
-	proto := "https://"
-	if !c.config.SSL {
-		proto = "http://"
-	}
-
-	url := proto + c.config.Hostname + ":" + strconv.Itoa(c.config.Port) + path
+	uri := path
 	if method != methodLogin && c.token != "" {
-		url = url + "?auth=" + c.token
+		uri = uri + "?auth=" + c.token
 	}
 
-	return url
+	return uri
 }
 
 func (c *lucirpc) call(ctx context.Context, url string, postBody []byte) ([]byte, error) {
 	logger.Log.Debug("call", zap.String("url", url), zap.String("postBody", string(postBody)))
+	// the router may run the request once its headers are written
+	var sent atomic.Bool
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		WroteHeaders: func() { sent.Store(true) },
+	})
+
 	body := bytes.NewReader(postBody)
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, body)
 	if err != nil {
@@ -158,6 +184,9 @@ func (c *lucirpc) call(ctx context.Context, url string, postBody []byte) ([]byte
 	req.Header.Set("Content-Type", "application/json")
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
+		if !sent.Load() {
+			return nil, &errNotSent{err: err}
+		}
 		return nil, err
 	}
 	defer resp.Body.Close()
@@ -165,7 +194,7 @@ func (c *lucirpc) call(ctx context.Context, url string, postBody []byte) ([]byte
 	var respBody []byte
 	respBody, err = io.ReadAll(resp.Body)
 	if resp.StatusCode > 226 {
-		return respBody, c.httpError(resp.StatusCode)
+		return respBody, &errStatus{err: c.httpError(resp.StatusCode)}
 	}
 
 	return respBody, err
@@ -183,7 +212,7 @@ func (c *lucirpc) httpError(code int) error {
 	return fmt.Errorf("http status code: %d", code)
 }
 
-func (c *lucirpc) rpcWithAuth(ctx context.Context, path, method string, params []string) (string, error) {
+func (c *lucirpc) rpcWithAuth(ctx context.Context, path, method string, params []any) (string, error) {
 	result, err := c.rpc(ctx, path, method, params)
 	if err == nil {
 		return result, nil
@@ -201,6 +230,15 @@ func (c *lucirpc) rpcWithAuth(ctx context.Context, path, method string, params [
 	return c.rpc(ctx, path, method, params)
 }
 
+func toParams(params []string) []any {
+	result := make([]any, 0, len(params)+1)
+	for _, param := range params {
+		result = append(result, param)
+	}
+
+	return result
+}
+
 func parseString(obj interface{}) (string, error) {
 	if obj == nil {
 		return "", errors.New("nil object cannot be parsed")
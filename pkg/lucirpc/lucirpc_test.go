@@ -2,6 +2,7 @@ package lucirpc
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -57,7 +58,7 @@ var _ = Describe("Luci RPC", func() {
 			config.Hostname = hostname
 			config.Port = port
 
-			client := lucirpc{
+			client := &lucirpc{
 				config:     config,
 				httpClient: ts.Client(),
 			}
@@ -92,7 +93,7 @@ var _ = Describe("Luci RPC", func() {
 			config.Hostname = hostname
 			config.Port = port
 
-			client := lucirpc{
+			client := &lucirpc{
 				config:     config,
 				httpClient: ts.Client(),
 			}
@@ -123,7 +124,7 @@ var _ = Describe("Luci RPC", func() {
 			config.Hostname = hostname
 			config.Port = port
 
-			client := lucirpc{
+			client := &lucirpc{
 				config:     config,
 				httpClient: ts.Client(),
 			}
@@ -154,7 +155,7 @@ var _ = Describe("Luci RPC", func() {
 			config.Hostname = hostname
 			config.Port = port
 
-			client := lucirpc{
+			client := &lucirpc{
 				config:     config,
 				httpClient: ts.Client(),
 			}
@@ -187,7 +188,7 @@ var _ = Describe("Luci RPC", func() {
 			config.Port = port
 			config.SSL = false
 
-			client := lucirpc{
+			client := &lucirpc{
 				config:     config,
 				httpClient: ts.Client(),
 			}
@@ -224,5 +225,41 @@ var _ = Describe("Luci RPC", func() {
 			Expect(authCalled).To(BeTrue())
 			Expect(client.token).To(Equal(expectedToken))
 		})
+
+		It("should set a list", func() {
+			mux := http.NewServeMux()
+			ts := httptest.NewServer(mux)
+			defer ts.Close()
+			u, err := url.Parse(ts.URL)
+			Expect(err).To(BeNil())
+			port, err := strconv.Atoi(u.Port())
+			Expect(err).To(BeNil())
+
+			config := DefaultConfig()
+			config.Hostname = u.Hostname()
+			config.Port = port
+			config.SSL = false
+
+			client := &lucirpc{
+				config:     config,
+				httpClient: ts.Client(),
+				token:      "foobar",
+			}
+
+			mux.HandleFunc(uciPath, func(w http.ResponseWriter, r *http.Request) {
+				var payload Payload
+				Expect(json.NewDecoder(r.Body).Decode(&payload)).To(Succeed())
+				Expect(payload.Method).To(Equal("set"))
+				Expect(payload.Params).To(Equal([]any{"dhcp", "@dnsmasq[0]", "address", []any{"/foo.com/1.1.1.1"}}))
+
+				w.WriteHeader(http.StatusOK)
+				_, err = w.Write([]byte(`{"result":true}`))
+				Expect(err).To(BeNil())
+			})
+
+			resp, err := client.UciList(ctx, "set", []string{"dhcp", "@dnsmasq[0]", "address"}, []string{"/foo.com/1.1.1.1"})
+			Expect(err).To(BeNil())
+			Expect(resp).To(Equal("true"))
+		})
 	})
 })
@@ -1,13 +1,102 @@
 package openwrt
 
-import "github.com/renanqts/external-dns-openwrt-webhook/pkg/lucirpc"
+import (
+	"slices"
+	"strings"
+
+	"github.com/renanqts/external-dns-openwrt-webhook/pkg/lucirpc"
+)
+
+const (
+	defaultBackend      = BackendDnsmasq
+	defaultLanInterface = "lan"
+	defaultPackage      = "dhcp"
+	defaultHostsFile    = "/etc/external-dns.hosts"
+	defaultUnboundFile  = "/etc/unbound/external-dns.conf"
+)
+
+// Instance routes the records of some domains to a dnsmasq instance
+type Instance struct {
+	Name    string   `mapstructure:"name"`
+	Domains []string `mapstructure:"domains"`
+}
 
 type Config struct {
 	LuciRPC *lucirpc.Config `mapstructure:"lucirpc"`
+	// Backend is where the records are stored: dnsmasq, unbound or hosts
+	Backend      string `mapstructure:"backend"`
+	LanInterface string `mapstructure:"lan_interface"`
+	// Package is the uci config holding the dnsmasq records
+	Package string `mapstructure:"package"`
+	// HostRecord stores address records as hostrecord sections,
+	// holding the A and AAAA records of a name and serving its PTR records
+	HostRecord bool `mapstructure:"hostrecord"`
+	// Instance is the dnsmasq instance of records not routed by Instances,
+	// records are not bound to any instance when it is empty
+	Instance  string     `mapstructure:"instance"`
+	Instances []Instance `mapstructure:"instances"`
+	// HostsFile is the file written by the hosts backend,
+	// dnsmasq has to list it in its addnhosts option
+	HostsFile string `mapstructure:"hosts_file"`
+	// UnboundFile is the file written by the unbound backend,
+	// unbound_srv.conf has to include it
+	UnboundFile string `mapstructure:"unbound_file"`
 }
 
 func DefaultConfig() *Config {
 	return &Config{
-		LuciRPC: lucirpc.DefaultConfig(),
+		LuciRPC:      lucirpc.DefaultConfig(),
+		Backend:      defaultBackend,
+		LanInterface: defaultLanInterface,
+		Package:      defaultPackage,
+		HostsFile:    defaultHostsFile,
+		UnboundFile:  defaultUnboundFile,
+	}
+}
+
+// Clone returns a deep copy of the config
+func (c *Config) Clone() *Config {
+	clone := *c
+	if c.LuciRPC != nil {
+		luciRPC := *c.LuciRPC
+		luciRPC.Endpoints = slices.Clone(c.LuciRPC.Endpoints)
+		clone.LuciRPC = &luciRPC
+	}
+
+	clone.Instances = slices.Clone(c.Instances)
+	for i := range clone.Instances {
+		clone.Instances[i].Domains = slices.Clone(clone.Instances[i].Domains)
+	}
+
+	return &clone
+}
+
+// instance returns the dnsmasq instance serving name
+func (c *Config) instance(name string) string {
+	name = strings.TrimPrefix(name, wildcardPrefix)
+	for _, instance := range c.Instances {
+		for _, domain := range instance.Domains {
+			domain = strings.Trim(domain, ".")
+			if name == domain || strings.HasSuffix(name, "."+domain) {
+				return instance.Name
+			}
+		}
+	}
+
+	return c.Instance
+}
+
+// manages reports whether records of the instance are handled by the webhook
+func (c *Config) manages(instance string) bool {
+	if instance == c.Instance {
+		return true
 	}
+
+	for _, i := range c.Instances {
+		if i.Name == instance {
+			return true
+		}
+	}
+
+	return false
 }
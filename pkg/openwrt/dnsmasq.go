@@ -0,0 +1,1008 @@
+package openwrt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net"
+	"net/netip"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/renanqts/external-dns-openwrt-webhook/pkg/logger"
+	"github.com/renanqts/external-dns-openwrt-webhook/pkg/lucirpc"
+	"go.uber.org/zap"
+)
+
+// addressOwnerOption is the uci list holding the owners of the addresses of a dnsmasq
+// section as address=owner entries, addresses cannot hold an owner themselves
+const addressOwnerOption = "address_owner"
+
+// dnsmasq stores records as sections of the dhcp config
+type dnsmasq struct {
+	config  *Config
+	lucirpc lucirpc.LuciRPC
+}
+
+func (d *dnsmasq) GetDNSRecords(ctx context.Context) (map[string]DNSRecord, error) {
+	sections, err := d.getSections(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	defaultSection, err := d.defaultSection(sections)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make(map[string]DNSRecord)
+	for key, data := range sections {
+		var sectionType struct {
+			Type string `json:".type"`
+		}
+		if err := json.Unmarshal(data, &sectionType); err != nil {
+			return nil, err
+		}
+
+		// hostrecord lists do not fit in a section
+		if sectionType.Type == "hostrecord" {
+			if err := d.getHostRecords(key, data, records); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		var record section
+		if err := json.Unmarshal(data, &record); err != nil {
+			return nil, err
+		}
+
+		if record.Type == "dnsmasq" {
+			// the addresses of a dnsmasq section belong to its instance
+			switch {
+			case key == defaultSection:
+				record.Instance = d.config.Instance
+			case d.config.manages(key):
+				record.Instance = key
+			default:
+				logger.Log.Debug("ignoring instance", zap.String("cfg", key))
+				continue
+			}
+		}
+
+		if !d.config.manages(record.Instance) {
+			logger.Log.Debug("ignoring instance", zap.String("cfg", key), zap.String("instance", record.Instance))
+			continue
+		}
+
+		switch record.Type {
+		case "domain":
+			records[key] = DNSRecord{
+				Type:      addressType(record.IP),
+				IP:        record.IP,
+				Name:      record.Name,
+				TTL:       record.TTL,
+				Owner:     record.Owner,
+				Labels:    parseLabels(record.Label),
+				Section:   key,
+				Misplaced: d.misplaced(record.Instance, record.Name),
+			}
+		case "cname":
+			records[key] = DNSRecord{
+				Type:      "CNAME",
+				CName:     record.CName,
+				Target:    record.Target,
+				TTL:       record.TTL,
+				Owner:     record.Owner,
+				Labels:    parseLabels(record.Label),
+				Section:   key,
+				Misplaced: d.misplaced(record.Instance, record.CName),
+			}
+		case "host":
+			// static leases without a name do not publish anything in the DNS
+			if record.Name == "" || record.IP == "" || record.MAC == "" {
+				logger.Log.Debug("ignoring static lease", zap.String("cfg", key))
+				continue
+			}
+
+			records[key] = DNSRecord{
+				Type:      "A",
+				IP:        record.IP,
+				Name:      record.Name,
+				MAC:       normalizeMAC(record.MAC),
+				TTL:       record.TTL,
+				Owner:     record.Owner,
+				Labels:    parseLabels(record.Label),
+				Section:   key,
+				Misplaced: d.misplaced(record.Instance, record.Name),
+			}
+		case "dnsmasq":
+			owners := parseLabels(record.AddressOwner)
+			for index, address := range toList(record.Address) {
+				domain, ip, ok := parseAddress(address)
+				if !ok {
+					logger.Log.Debug("ignoring address", zap.String("address", address))
+					continue
+				}
+
+				records[fmt.Sprintf("%s.address.%d", key, index)] = DNSRecord{
+					Type:      addressType(ip),
+					IP:        ip,
+					Name:      wildcardPrefix + domain,
+					Owner:     owners[address],
+					Section:   key,
+					Misplaced: d.misplaced(record.Instance, wildcardPrefix+domain),
+				}
+			}
+		default:
+			// it does not care about other types
+			logger.Log.Debug("ignoring record", zap.String("type", record.Type))
+		}
+	}
+
+	logger.Log.Debug("current records", zap.Any("records", records))
+	return records, nil
+}
+
+// getHostRecords adds the records of a hostrecord section, a record per ip.
+// Sections with several names are not managed, changing their ips would
+// change the records of every name.
+func (d *dnsmasq) getHostRecords(key string, data json.RawMessage, records map[string]DNSRecord) error {
+	var record hostRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return fmt.Errorf("invalid section %s: %w", key, err)
+	}
+
+	if !d.config.manages(record.Instance) {
+		logger.Log.Debug("ignoring instance", zap.String("cfg", key), zap.String("instance", record.Instance))
+		return nil
+	}
+
+	names := toList(record.Name)
+	if len(names) != 1 {
+		logger.Log.Debug("ignoring host record", zap.String("cfg", key), zap.Strings("names", names))
+		return nil
+	}
+
+	for index, ip := range toList(record.IP) {
+		records[fmt.Sprintf("%s.ip.%d", key, index)] = DNSRecord{
+			Type:      addressType(ip),
+			IP:        ip,
+			Name:      names[0],
+			TTL:       record.TTL,
+			Owner:     record.Owner,
+			Labels:    parseLabels(record.Label),
+			Section:   key,
+			Misplaced: d.misplaced(record.Instance, names[0]),
+			stored:    "hostrecord",
+		}
+	}
+
+	return nil
+}
+
+func (d *dnsmasq) AddDNSRecord(ctx context.Context, record DNSRecord) error {
+	if err := d.addRecord(ctx, record); err != nil {
+		return err
+	}
+	logger.Log.Debug("added record", zap.Any("record", record))
+
+	return nil
+}
+
+// UpdateDNSRecord changes the record in place,
+// keeping its section and any option not managed by the webhook
+func (d *dnsmasq) UpdateDNSRecord(ctx context.Context, current, desired DNSRecord) error {
+	return d.updateRecord(ctx, current, desired)
+}
+
+func (d *dnsmasq) DeleteDNSRecord(ctx context.Context, record DNSRecord) error {
+	if err := d.deleteRecord(ctx, record); err != nil {
+		return err
+	}
+	logger.Log.Debug("deleted record", zap.Any("record", record))
+
+	return nil
+}
+
+func (d *dnsmasq) Commit(ctx context.Context) error {
+	if _, err := d.lucirpc.Uci(ctx, "commit", []string{d.config.Package}); err != nil {
+		return err
+	}
+	logger.Log.Debug("committed changes")
+
+	return nil
+}
+
+func (d *dnsmasq) Reload(ctx context.Context) error {
+	if _, err := d.lucirpc.Sys(ctx, "init.reload", []string{"dnsmasq"}); err != nil {
+		return err
+	}
+	logger.Log.Debug("reloaded dnsmasq")
+
+	return nil
+}
+
+// Revert discards every change staged since the last commit
+func (d *dnsmasq) Revert(ctx context.Context) error {
+	if _, err := d.lucirpc.Uci(ctx, "revert", []string{d.config.Package}); err != nil {
+		return err
+	}
+	logger.Log.Info("reverted changes")
+
+	return nil
+}
+
+func (d *dnsmasq) addRecord(ctx context.Context, record DNSRecord) error {
+	if err := ValidateRecord(record); err != nil {
+		return err
+	}
+
+	switch record.Type {
+	case "A", "AAAA":
+		return d.addA(ctx, record)
+	case "CNAME":
+		return d.addCName(ctx, record)
+	default:
+		return fmt.Errorf("invalid record type: %s", record.Type)
+	}
+}
+
+// updateRecord sets only the options which differ from the current record,
+// a misplaced record is moved to the instance serving its name
+func (d *dnsmasq) updateRecord(ctx context.Context, current, desired DNSRecord) error {
+	// entries of shared sections move along with their section
+	shared := current.storedIn() == "dnsmasq" || current.storedIn() == "hostrecord"
+	if current.storedIn() != d.storeIn(desired) || (current.Misplaced && shared) {
+		// the record moves to another kind of section, e.g. it got a mac, or to another instance
+		if err := d.deleteRecord(ctx, current); err != nil {
+			return err
+		}
+
+		if err := d.addRecord(ctx, desired); err != nil {
+			return err
+		}
+
+		logger.Log.Debug("replaced record", zap.Any("current", current), zap.Any("desired", desired))
+		return nil
+	}
+
+	if err := d.validate(ctx, desired); err != nil {
+		return err
+	}
+
+	switch current.storedIn() {
+	case "dnsmasq":
+		return d.updateAddress(ctx, current, desired)
+	case "hostrecord":
+		return d.updateHostRecord(ctx, current, desired)
+	}
+
+	currentOptions := current.options()
+	desiredOptions := desired.options()
+	options := make([]string, 0, len(desiredOptions))
+	for option := range desiredOptions {
+		options = append(options, option)
+	}
+	sort.Strings(options)
+
+	for _, option := range options {
+		if currentOptions[option] == desiredOptions[option] {
+			continue
+		}
+
+		if _, err := d.lucirpc.Uci(ctx, "set", []string{d.config.Package, current.Section, option, desiredOptions[option]}); err != nil {
+			return err
+		}
+		logger.Log.Debug("updated record", zap.String("cfg", current.Section), zap.String(option, desiredOptions[option]))
+	}
+
+	// options the desired record does not have anymore, e.g. its ttl
+	for _, option := range slices.Sorted(maps.Keys(currentOptions)) {
+		if _, ok := desiredOptions[option]; ok {
+			continue
+		}
+
+		if _, err := d.lucirpc.Uci(ctx, "delete", []string{d.config.Package, current.Section, option}); err != nil {
+			return err
+		}
+		logger.Log.Debug("deleted option", zap.String("cfg", current.Section), zap.String("option", option))
+	}
+
+	if current.Misplaced {
+		if err := d.updateInstance(ctx, current.Section, desired.Key().Name); err != nil {
+			return err
+		}
+	}
+
+	return d.updateLabels(ctx, current.Section, current.Labels, desired.Labels)
+}
+
+// validate checks the record as addA and addCName do before adding it
+func (d *dnsmasq) validate(ctx context.Context, record DNSRecord) error {
+	if err := ValidateRecord(record); err != nil {
+		return err
+	}
+
+	switch d.storeIn(record) {
+	case "cname":
+		if record.Target == "" {
+			return fmt.Errorf("target is required")
+		}
+	case "host":
+		if _, err := d.validateHost(ctx, record); err != nil {
+			return err
+		}
+	case "dnsmasq", "hostrecord":
+		if _, err := netip.ParseAddr(record.IP); err != nil {
+			return fmt.Errorf("invalid ip: %s", record.IP)
+		}
+	default:
+		if record.IP == "" {
+			return fmt.Errorf("ip is required")
+		}
+	}
+
+	return nil
+}
+
+func (d *dnsmasq) addA(ctx context.Context, record DNSRecord) error {
+	if record.Type != "a" && record.Type != "A" && record.Type != "AAAA" {
+		return fmt.Errorf("invalid record type: %s", record.Type)
+	}
+
+	if record.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+
+	if record.IP == "" {
+		return fmt.Errorf("ip is required")
+	}
+
+	if record.MAC != "" {
+		return d.addHost(ctx, record)
+	}
+
+	if isWildcard(record.Name) {
+		return d.addAddress(ctx, record)
+	}
+
+	if d.config.HostRecord {
+		return d.addHostRecord(ctx, record)
+	}
+
+	cfg, err := d.lucirpc.Uci(ctx, "add", []string{d.config.Package, "domain"})
+	if err != nil {
+		return err
+	}
+
+	if err := d.setInstance(ctx, cfg, record.Name); err != nil {
+		return err
+	}
+
+	if _, err := d.lucirpc.Uci(ctx, "set", []string{d.config.Package, cfg, "name", record.Name}); err != nil {
+		return err
+	}
+
+	if _, err := d.lucirpc.Uci(ctx, "set", []string{d.config.Package, cfg, "ip", record.IP}); err != nil {
+		return err
+	}
+
+	if err := d.updateTTL(ctx, cfg, "", record.TTL); err != nil {
+		return err
+	}
+
+	if err := d.updateLabels(ctx, cfg, nil, record.Labels); err != nil {
+		return err
+	}
+
+	return d.setOwner(ctx, cfg, record.Owner)
+}
+
+func (d *dnsmasq) addCName(ctx context.Context, record DNSRecord) error {
+	if record.Type != "cname" && record.Type != "CNAME" {
+		return fmt.Errorf("invalid record type: %s", record.Type)
+	}
+
+	if record.CName == "" {
+		return fmt.Errorf("cname is required")
+	}
+
+	if record.Target == "" {
+		return fmt.Errorf("target is required")
+	}
+
+	cfg, err := d.lucirpc.Uci(ctx, "add", []string{d.config.Package, "cname"})
+	if err != nil {
+		return err
+	}
+
+	if err := d.setInstance(ctx, cfg, record.CName); err != nil {
+		return err
+	}
+
+	if _, err := d.lucirpc.Uci(ctx, "set", []string{d.config.Package, cfg, "cname", record.CName}); err != nil {
+		return err
+	}
+
+	if _, err := d.lucirpc.Uci(ctx, "set", []string{d.config.Package, cfg, "target", record.Target}); err != nil {
+		return err
+	}
+
+	if err := d.updateTTL(ctx, cfg, "", record.TTL); err != nil {
+		return err
+	}
+
+	if err := d.updateLabels(ctx, cfg, nil, record.Labels); err != nil {
+		return err
+	}
+
+	return d.setOwner(ctx, cfg, record.Owner)
+}
+
+func (d *dnsmasq) addHost(ctx context.Context, record DNSRecord) error {
+	mac, err := d.validateHost(ctx, record)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := d.lucirpc.Uci(ctx, "add", []string{d.config.Package, "host"})
+	if err != nil {
+		return err
+	}
+
+	if err := d.setInstance(ctx, cfg, record.Name); err != nil {
+		return err
+	}
+
+	if _, err := d.lucirpc.Uci(ctx, "set", []string{d.config.Package, cfg, "name", record.Name}); err != nil {
+		return err
+	}
+
+	if _, err := d.lucirpc.Uci(ctx, "set", []string{d.config.Package, cfg, "mac", mac.String()}); err != nil {
+		return err
+	}
+
+	if _, err := d.lucirpc.Uci(ctx, "set", []string{d.config.Package, cfg, "ip", record.IP}); err != nil {
+		return err
+	}
+
+	if _, err := d.lucirpc.Uci(ctx, "set", []string{d.config.Package, cfg, "dns", "1"}); err != nil {
+		return err
+	}
+
+	if err := d.updateTTL(ctx, cfg, "", record.TTL); err != nil {
+		return err
+	}
+
+	if err := d.updateLabels(ctx, cfg, nil, record.Labels); err != nil {
+		return err
+	}
+
+	return d.setOwner(ctx, cfg, record.Owner)
+}
+
+// addHostRecord appends the ip to the hostrecord section of the name,
+// the section is added when the name has none yet.
+// The ttl is shared by every ip of the section.
+func (d *dnsmasq) addHostRecord(ctx context.Context, record DNSRecord) error {
+	_, err := d.storeHostRecord(ctx, record)
+	return err
+}
+
+// storeHostRecord adds the record as addHostRecord does and returns the section holding it
+func (d *dnsmasq) storeHostRecord(ctx context.Context, record DNSRecord) (string, error) {
+	if _, err := netip.ParseAddr(record.IP); err != nil {
+		return "", fmt.Errorf("invalid ip: %s", record.IP)
+	}
+
+	records, err := d.GetDNSRecords(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(records)) {
+		current := records[key]
+		if current.stored != "hostrecord" || current.Name != record.Name || current.Misplaced {
+			continue
+		}
+
+		ips, err := d.getList(ctx, current.Section, "ip")
+		if err != nil {
+			return "", err
+		}
+
+		if _, err := d.lucirpc.UciList(ctx, "set", []string{d.config.Package, current.Section, "ip"}, append(ips, record.IP)); err != nil {
+			return "", err
+		}
+
+		if err := d.updateTTL(ctx, current.Section, current.TTL, record.TTL); err != nil {
+			return "", err
+		}
+
+		return current.Section, d.updateLabels(ctx, current.Section, current.Labels, record.Labels)
+	}
+
+	cfg, err := d.lucirpc.Uci(ctx, "add", []string{d.config.Package, "hostrecord"})
+	if err != nil {
+		return "", err
+	}
+
+	if err := d.setInstance(ctx, cfg, record.Name); err != nil {
+		return "", err
+	}
+
+	if _, err := d.lucirpc.Uci(ctx, "set", []string{d.config.Package, cfg, "name", record.Name}); err != nil {
+		return "", err
+	}
+
+	if _, err := d.lucirpc.UciList(ctx, "set", []string{d.config.Package, cfg, "ip"}, []string{record.IP}); err != nil {
+		return "", err
+	}
+
+	if err := d.updateTTL(ctx, cfg, "", record.TTL); err != nil {
+		return "", err
+	}
+
+	if err := d.updateLabels(ctx, cfg, nil, record.Labels); err != nil {
+		return "", err
+	}
+
+	return cfg, d.setOwner(ctx, cfg, record.Owner)
+}
+
+// updateHostRecord replaces the ip keeping its position in the ip list
+func (d *dnsmasq) updateHostRecord(ctx context.Context, current, desired DNSRecord) error {
+	if err := d.updateTTL(ctx, current.Section, current.TTL, desired.TTL); err != nil {
+		return err
+	}
+
+	if err := d.updateLabels(ctx, current.Section, current.Labels, desired.Labels); err != nil {
+		return err
+	}
+
+	if current.IP == desired.IP {
+		return nil
+	}
+
+	ips, err := d.getList(ctx, current.Section, "ip")
+	if err != nil {
+		return err
+	}
+
+	for index, ip := range ips {
+		if ip == current.IP {
+			ips[index] = desired.IP
+		}
+	}
+
+	_, err = d.lucirpc.UciList(ctx, "set", []string{d.config.Package, current.Section, "ip"}, ips)
+	return err
+}
+
+// deleteHostRecord removes the ip from the ip list,
+// the section is deleted along with its last ip
+func (d *dnsmasq) deleteHostRecord(ctx context.Context, record DNSRecord) error {
+	ips, err := d.getList(ctx, record.Section, "ip")
+	if err != nil {
+		return err
+	}
+
+	kept := slices.DeleteFunc(ips, func(ip string) bool { return ip == record.IP })
+	if len(kept) == 0 {
+		_, err = d.lucirpc.Uci(ctx, "delete", []string{d.config.Package, record.Section})
+		return err
+	}
+
+	_, err = d.lucirpc.UciList(ctx, "set", []string{d.config.Package, record.Section, "ip"}, kept)
+	return err
+}
+
+// setOwner sets the owner option of a new section
+func (d *dnsmasq) setOwner(ctx context.Context, cfg, owner string) error {
+	if owner == "" {
+		return nil
+	}
+
+	_, err := d.lucirpc.Uci(ctx, "set", []string{d.config.Package, cfg, "owner", owner})
+	return err
+}
+
+// updateTTL changes the ttl option of the section, it is deleted when desired is empty
+func (d *dnsmasq) updateTTL(ctx context.Context, cfg, current, desired string) error {
+	switch {
+	case current == desired:
+		return nil
+	case desired == "":
+		_, err := d.lucirpc.Uci(ctx, "delete", []string{d.config.Package, cfg, "ttl"})
+		return err
+	default:
+		_, err := d.lucirpc.Uci(ctx, "set", []string{d.config.Package, cfg, "ttl", desired})
+		return err
+	}
+}
+
+// updateLabels changes the label list of the section
+func (d *dnsmasq) updateLabels(ctx context.Context, cfg string, current, desired map[string]string) error {
+	return updateLabels(ctx, d.lucirpc, d.config.Package, cfg, current, desired)
+}
+
+// setInstance binds the section to the dnsmasq instance serving name
+func (d *dnsmasq) setInstance(ctx context.Context, cfg, name string) error {
+	instance := d.config.instance(name)
+	if instance == "" {
+		return nil
+	}
+
+	_, err := d.lucirpc.Uci(ctx, "set", []string{d.config.Package, cfg, "instance", instance})
+	return err
+}
+
+// updateInstance binds the section to the dnsmasq instance serving name,
+// the instance option is deleted when no instance serves it
+func (d *dnsmasq) updateInstance(ctx context.Context, cfg, name string) error {
+	instance := d.config.instance(name)
+	if instance == "" {
+		_, err := d.lucirpc.Uci(ctx, "delete", []string{d.config.Package, cfg, "instance"})
+		return err
+	}
+
+	_, err := d.lucirpc.Uci(ctx, "set", []string{d.config.Package, cfg, "instance", instance})
+	return err
+}
+
+// misplaced reports whether a record of name stored in the instance
+// belongs to another instance
+func (d *dnsmasq) misplaced(instance, name string) bool {
+	return instance != d.config.instance(name)
+}
+
+// validateHost checks the mac and that the ip belongs to the lan subnet
+func (d *dnsmasq) validateHost(ctx context.Context, record DNSRecord) (net.HardwareAddr, error) {
+	mac, err := net.ParseMAC(record.MAC)
+	if err != nil || len(mac) != 6 {
+		return nil, fmt.Errorf("invalid mac: %s", record.MAC)
+	}
+
+	ip, err := netip.ParseAddr(record.IP)
+	if err != nil || !ip.Is4() {
+		return nil, fmt.Errorf("invalid ip: %s", record.IP)
+	}
+
+	subnet, err := d.lanSubnet(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !subnet.Contains(ip) {
+		return nil, fmt.Errorf("ip %s is outside of the lan subnet %s", record.IP, subnet)
+	}
+
+	return mac, nil
+}
+
+// addAddress appends a wildcard record to the address list of dnsmasq
+func (d *dnsmasq) addAddress(ctx context.Context, record DNSRecord) error {
+	if _, err := netip.ParseAddr(record.IP); err != nil {
+		return fmt.Errorf("invalid ip: %s", record.IP)
+	}
+
+	// the dnsmasq section of an instance is named after it
+	cfg := d.config.instance(record.Name)
+	if cfg == "" {
+		sections, err := d.getSections(ctx)
+		if err != nil {
+			return err
+		}
+
+		if cfg, err = d.defaultSection(sections); err != nil {
+			return err
+		}
+		if cfg == "" {
+			return fmt.Errorf("no dnsmasq section in %s", d.config.Package)
+		}
+	}
+
+	addresses, err := d.getAddresses(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	entry := formatAddress(record)
+	addresses = append(addresses, entry)
+	if _, err := d.lucirpc.UciList(ctx, "set", []string{d.config.Package, cfg, "address"}, addresses); err != nil {
+		return err
+	}
+
+	if record.Owner == "" {
+		return nil
+	}
+
+	return d.updateAddressOwner(ctx, cfg, "", entry, record.Owner)
+}
+
+// updateAddress replaces a wildcard record keeping its position in the address list
+func (d *dnsmasq) updateAddress(ctx context.Context, current, desired DNSRecord) error {
+	currentEntry, desiredEntry := formatAddress(current), formatAddress(desired)
+	if currentEntry == desiredEntry && current.Owner == desired.Owner {
+		return nil
+	}
+
+	addresses, err := d.getAddresses(ctx, current.Section)
+	if err != nil {
+		return err
+	}
+
+	if currentEntry != desiredEntry {
+		for index, address := range addresses {
+			if address == currentEntry {
+				addresses[index] = desiredEntry
+			}
+		}
+
+		if _, err := d.lucirpc.UciList(ctx, "set", []string{d.config.Package, current.Section, "address"}, addresses); err != nil {
+			return err
+		}
+	}
+
+	if current.Owner == "" && desired.Owner == "" {
+		return nil
+	}
+
+	return d.updateAddressOwner(ctx, current.Section, currentEntry, desiredEntry, desired.Owner)
+}
+
+// deleteRecord removes the record from the section holding it
+func (d *dnsmasq) deleteRecord(ctx context.Context, record DNSRecord) error {
+	switch record.storedIn() {
+	case "dnsmasq":
+		return d.deleteAddress(ctx, record)
+	case "hostrecord":
+		return d.deleteHostRecord(ctx, record)
+	}
+
+	_, err := d.lucirpc.Uci(ctx, "delete", []string{d.config.Package, record.Section})
+	return err
+}
+
+// deleteAddress removes a wildcard record from the address list of dnsmasq
+func (d *dnsmasq) deleteAddress(ctx context.Context, record DNSRecord) error {
+	addresses, err := d.getAddresses(ctx, record.Section)
+	if err != nil {
+		return err
+	}
+
+	entry := formatAddress(record)
+	var kept []string
+	for _, address := range addresses {
+		if address != entry {
+			kept = append(kept, address)
+		}
+	}
+
+	if len(kept) == 0 {
+		_, err = d.lucirpc.Uci(ctx, "delete", []string{d.config.Package, record.Section, "address"})
+	} else {
+		_, err = d.lucirpc.UciList(ctx, "set", []string{d.config.Package, record.Section, "address"}, kept)
+	}
+	if err != nil || record.Owner == "" {
+		return err
+	}
+
+	return d.updateAddressOwner(ctx, record.Section, entry, "", "")
+}
+
+// updateAddressOwner replaces the owner entry of the current address by one of the
+// desired address, nothing is added when desired or owner are empty
+func (d *dnsmasq) updateAddressOwner(ctx context.Context, cfg, current, desired, owner string) error {
+	entries, err := d.getList(ctx, cfg, addressOwnerOption)
+	if err != nil {
+		return err
+	}
+
+	owners := parseLabels(entries)
+	if owners == nil {
+		owners = make(map[string]string)
+	}
+	delete(owners, current)
+	if desired != "" && owner != "" {
+		owners[desired] = owner
+	}
+
+	if len(owners) == 0 {
+		if len(entries) == 0 {
+			return nil
+		}
+		_, err = d.lucirpc.Uci(ctx, "delete", []string{d.config.Package, cfg, addressOwnerOption})
+		return err
+	}
+
+	_, err = d.lucirpc.UciList(ctx, "set", []string{d.config.Package, cfg, addressOwnerOption}, formatLabels(owners))
+	return err
+}
+
+// getSections returns the sections of the package by name
+func (d *dnsmasq) getSections(ctx context.Context) (map[string]json.RawMessage, error) {
+	result, err := d.lucirpc.Uci(ctx, "get_all", []string{d.config.Package})
+	if err != nil {
+		return nil, err
+	}
+
+	var sections map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(result), &sections); err != nil {
+		return nil, err
+	}
+
+	return sections, nil
+}
+
+// defaultSection returns the dnsmasq section holding the addresses of records bound to
+// no instance: the section of the instance when one is set, otherwise the first dnsmasq
+// section which is not a configured instance. It is empty when there is none.
+func (d *dnsmasq) defaultSection(sections map[string]json.RawMessage) (string, error) {
+	if d.config.Instance != "" {
+		return d.config.Instance, nil
+	}
+
+	found, index := "", 0
+	for key, data := range sections {
+		var header struct {
+			Type  string `json:".type"`
+			Index int    `json:".index"`
+		}
+		if err := json.Unmarshal(data, &header); err != nil {
+			return "", err
+		}
+
+		if header.Type != "dnsmasq" || d.config.manages(key) {
+			continue
+		}
+
+		// sections are returned in no particular order
+		if found == "" || header.Index < index || header.Index == index && key < found {
+			found, index = key, header.Index
+		}
+	}
+
+	return found, nil
+}
+
+func (d *dnsmasq) getAddresses(ctx context.Context, cfg string) ([]string, error) {
+	return d.getList(ctx, cfg, "address")
+}
+
+// getList returns the values of a list option, options set as a single value are
+// returned as a list of one
+func (d *dnsmasq) getList(ctx context.Context, cfg, option string) ([]string, error) {
+	result, err := d.lucirpc.Uci(ctx, "get", []string{d.config.Package, cfg, option})
+	if err != nil {
+		return nil, err
+	}
+
+	// the option is not set yet
+	if result == "" {
+		return nil, nil
+	}
+
+	if !strings.HasPrefix(result, "[") {
+		return []string{result}, nil
+	}
+
+	var values []string
+	if err := json.Unmarshal([]byte(result), &values); err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+// lanSubnet returns the IPv4 subnet of the configured LAN interface
+func (d *dnsmasq) lanSubnet(ctx context.Context) (netip.Prefix, error) {
+	result, err := d.lucirpc.Uci(ctx, "get_all", []string{"network", d.config.LanInterface})
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+
+	var lan lanInterface
+	if err := json.Unmarshal([]byte(result), &lan); err != nil {
+		return netip.Prefix{}, err
+	}
+
+	// ipaddr is either a single address or a list of CIDRs in newer releases
+	var ipaddr string
+	switch v := lan.IPAddr.(type) {
+	case string:
+		ipaddr = v
+	case []any:
+		if len(v) > 0 {
+			ipaddr, _ = v[0].(string)
+		}
+	}
+
+	if strings.Contains(ipaddr, "/") {
+		prefix, err := netip.ParsePrefix(ipaddr)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		return prefix.Masked(), nil
+	}
+
+	addr, err := netip.ParseAddr(ipaddr)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid %s ipaddr: %q", d.config.LanInterface, ipaddr)
+	}
+
+	mask := net.ParseIP(lan.Netmask).To4()
+	if mask == nil {
+		return netip.Prefix{}, fmt.Errorf("invalid %s netmask: %q", d.config.LanInterface, lan.Netmask)
+	}
+	bits, _ := net.IPMask(mask).Size()
+
+	return netip.PrefixFrom(addr, bits).Masked(), nil
+}
+
+// storeIn returns the type of the section the record is added to
+func (d *dnsmasq) storeIn(record DNSRecord) string {
+	sectionType := record.sectionType()
+	if sectionType == "domain" && d.config.HostRecord {
+		return "hostrecord"
+	}
+
+	return sectionType
+}
+
+// toList returns the values of an option which is either a single value or a list
+func toList(value any) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	case []any:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+
+	return nil
+}
+
+// normalizeMAC returns the mac in the lower case colon form of net.HardwareAddr,
+// so leases written by hand compare equal to the ones of the webhook
+func normalizeMAC(mac string) string {
+	if hw, err := net.ParseMAC(mac); err == nil {
+		return hw.String()
+	}
+
+	return strings.ToLower(mac)
+}
+
+func isWildcard(name string) bool {
+	return strings.HasPrefix(name, wildcardPrefix)
+}
+
+// formatAddress renders a wildcard record as a dnsmasq address, e.g. /apps.home.lan/1.1.1.1
+func formatAddress(record DNSRecord) string {
+	return "/" + strings.TrimPrefix(record.Name, wildcardPrefix) + "/" + record.IP
+}
+
+// parseAddress parses dnsmasq addresses for a single domain and ip,
+// other forms like /a/b/1.1.1.1 or /domain/# are not managed by the webhook
+func parseAddress(address string) (string, string, bool) {
+	parts := strings.Split(address, "/")
+	if len(parts) != 3 || parts[0] != "" || parts[1] == "" {
+		return "", "", false
+	}
+
+	if _, err := netip.ParseAddr(parts[2]); err != nil {
+		return "", "", false
+	}
+
+	return parts[1], parts[2], true
+}
@@ -0,0 +1,854 @@
+package openwrt
+
+import (
+	"context"
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	mocks "github.com/renanqts/external-dns-openwrt-webhook/internal/mocks/lucirpc"
+	"go.uber.org/mock/gomock"
+)
+
+var _ = Describe("Dnsmasq", func() {
+	var (
+		ctx         context.Context
+		mockCtrl    *gomock.Controller
+		mockLuciRPC *mocks.MockLuciRPC
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		mockCtrl = gomock.NewController(GinkgoT())
+		mockLuciRPC = mocks.NewMockLuciRPC(mockCtrl)
+	})
+
+	AfterEach(func() {
+		mockCtrl.Finish()
+	})
+
+	Context("Get DNS", func() {
+		It("get all records", func() {
+			expectedJson, err := json.Marshal(map[string]section{
+				"x": {
+					DNSRecord: DNSRecord{
+						Type: "domain",
+						Name: "foobar",
+						IP:   "1.1.1.1",
+					},
+				},
+				"y": {
+					DNSRecord: DNSRecord{
+						Type:   "cname",
+						CName:  "foobar",
+						Target: "bar.foo.com",
+					},
+				},
+				"z": {
+					DNSRecord: DNSRecord{
+						Type: "whatever",
+					},
+				},
+				"t": {
+					DNSRecord: DNSRecord{
+						Type: "domain",
+						Name: "foobar",
+						IP:   "2001:db8::1",
+					},
+				},
+				"w": {
+					DNSRecord: DNSRecord{
+						Type: "host",
+						Name: "node",
+						IP:   "192.168.1.10",
+						MAC:  "AA:BB:CC:DD:EE:FF",
+					},
+				},
+				"v": {
+					DNSRecord: DNSRecord{
+						Type: "host",
+						MAC:  "AA:BB:CC:DD:EE:00",
+					},
+				},
+				"u": {
+					DNSRecord: DNSRecord{
+						Type: "dnsmasq",
+					},
+					Address: []string{"/apps.foo.com/2.2.2.2", "/foo.com/bar.com/3.3.3.3", "/ads.com/"},
+				},
+			})
+			Expect(err).To(BeNil())
+			mockLuciRPC.EXPECT().Uci(ctx, "get_all", []string{"dhcp"}).Return(string(expectedJson), nil)
+			d := dnsmasq{
+				config:  DefaultConfig(),
+				lucirpc: mockLuciRPC,
+			}
+			resultDNS, err := d.GetDNSRecords(ctx)
+			Expect(err).To(BeNil())
+			Expect(resultDNS).ToNot(BeNil())
+			Expect(resultDNS).To(Equal(map[string]DNSRecord{
+				"x": {
+					Type:    "A",
+					Name:    "foobar",
+					IP:      "1.1.1.1",
+					Section: "x",
+				},
+				"y": {
+					Type:    "CNAME",
+					CName:   "foobar",
+					Target:  "bar.foo.com",
+					Section: "y",
+				},
+				"t": {
+					Type:    "AAAA",
+					Name:    "foobar",
+					IP:      "2001:db8::1",
+					Section: "t",
+				},
+				"w": {
+					Type:    "A",
+					Name:    "node",
+					IP:      "192.168.1.10",
+					MAC:     "aa:bb:cc:dd:ee:ff",
+					Section: "w",
+				},
+				"u.address.0": {
+					Type:    "A",
+					Name:    "*.apps.foo.com",
+					IP:      "2.2.2.2",
+					Section: "u",
+				},
+			}))
+		})
+		It("should read the owners of addresses", func() {
+			mockLuciRPC.EXPECT().Uci(ctx, "get_all", []string{"dhcp"}).
+				Return(`{"u": {".type": "dnsmasq", "address": ["/apps.foo.com/2.2.2.2", "/ads.com/3.3.3.3"], "address_owner": "/apps.foo.com/2.2.2.2=external-dns"}}`, nil)
+			d := dnsmasq{
+				config:  DefaultConfig(),
+				lucirpc: mockLuciRPC,
+			}
+			resultDNS, err := d.GetDNSRecords(ctx)
+			Expect(err).To(BeNil())
+			Expect(resultDNS["u.address.0"].Owner).To(Equal("external-dns"))
+			Expect(resultDNS["u.address.1"].Owner).To(BeEmpty())
+		})
+		It("should read an address set as a single value", func() {
+			mockLuciRPC.EXPECT().Uci(ctx, "get_all", []string{"dhcp"}).
+				Return(`{"u": {".type": "dnsmasq", "address": "/apps.foo.com/2.2.2.2"}}`, nil)
+			d := dnsmasq{
+				config:  DefaultConfig(),
+				lucirpc: mockLuciRPC,
+			}
+			resultDNS, err := d.GetDNSRecords(ctx)
+			Expect(err).To(BeNil())
+			Expect(resultDNS).To(Equal(map[string]DNSRecord{
+				"u.address.0": {
+					Type:    "A",
+					Name:    "*.apps.foo.com",
+					IP:      "2.2.2.2",
+					Section: "u",
+				},
+			}))
+		})
+	})
+
+	Context("Add DNS", func() {
+		It("set A record with success", func() {
+			cfg := "foobar"
+			ip := "1.1.1.1"
+			name := "foo.bar.com"
+
+			mockLuciRPC.EXPECT().Uci(ctx, "add", []string{"dhcp", "domain"}).Return(cfg, nil)
+			mockLuciRPC.EXPECT().Uci(ctx, "set", []string{"dhcp", cfg, "name", name}).Return("", nil)
+			mockLuciRPC.EXPECT().Uci(ctx, "set", []string{"dhcp", cfg, "ip", ip}).Return("", nil)
+
+			d := dnsmasq{
+				config:  DefaultConfig(),
+				lucirpc: mockLuciRPC,
+			}
+			err := d.AddDNSRecord(ctx, DNSRecord{
+				Type: "A",
+				IP:   ip,
+				Name: name,
+			})
+			Expect(err).To(BeNil())
+		})
+
+		It("A without name", func() {
+			d := dnsmasq{config: DefaultConfig()}
+			err := d.AddDNSRecord(ctx, DNSRecord{
+				Type: "A",
+				IP:   "1.1.1.1",
+			})
+			Expect(err).ToNot(BeNil())
+			Expect(err.Error()).To(Equal("name is required"))
+		})
+
+		It("A without ip", func() {
+			d := dnsmasq{config: DefaultConfig()}
+			err := d.AddDNSRecord(ctx, DNSRecord{
+				Type: "A",
+				Name: "foobar",
+			})
+			Expect(err).ToNot(BeNil())
+			Expect(err.Error()).To(Equal("ip is required"))
+		})
+
+		It("set host record with success", func() {
+			cfg := "foobar"
+			ip := "192.168.1.10"
+			name := "node"
+
+			mockLuciRPC.EXPECT().Uci(ctx, "get_all", []string{"network", "lan"}).
+				Return(`{"ipaddr":"192.168.1.1","netmask":"255.255.255.0"}`, nil)
+			mockLuciRPC.EXPECT().Uci(ctx, "add", []string{"dhcp", "host"}).Return(cfg, nil)
+			mockLuciRPC.EXPECT().Uci(ctx, "set", []string{"dhcp", cfg, "name", name}).Return("", nil)
+			mockLuciRPC.EXPECT().Uci(ctx, "set", []string{"dhcp", cfg, "mac", "aa:bb:cc:dd:ee:ff"}).Return("", nil)
+			mockLuciRPC.EXPECT().Uci(ctx, "set", []string{"dhcp", cfg, "ip", ip}).Return("", nil)
+			mockLuciRPC.EXPECT().Uci(ctx, "set", []string{"dhcp", cfg, "dns", "1"}).Return("", nil)
+
+			d := dnsmasq{
+				config:  DefaultConfig(),
+				lucirpc: mockLuciRPC,
+			}
+			err := d.AddDNSRecord(ctx, DNSRecord{
+				Type: "A",
+				IP:   ip,
+				Name: name,
+				MAC:  "AA:BB:CC:DD:EE:FF",
+			})
+			Expect(err).To(BeNil())
+		})
+
+		It("host with invalid mac", func() {
+			d := dnsmasq{config: DefaultConfig()}
+			err := d.AddDNSRecord(ctx, DNSRecord{
+				Type: "A",
+				IP:   "192.168.1.10",
+				Name: "node",
+				MAC:  "aa:bb:cc",
+			})
+			Expect(err).ToNot(BeNil())
+			Expect(err.Error()).To(Equal("invalid mac: aa:bb:cc"))
+		})
+
+		It("host outside of the lan subnet", func() {
+			mockLuciRPC.EXPECT().Uci(ctx, "get_all", []string{"network", "lan"}).
+				Return(`{"ipaddr":["192.168.1.1/24"]}`, nil)
+
+			d := dnsmasq{
+				config:  DefaultConfig(),
+				lucirpc: mockLuciRPC,
+			}
+			err := d.AddDNSRecord(ctx, DNSRecord{
+				Type: "A",
+				IP:   "10.0.0.10",
+				Name: "node",
+				MAC:  "aa:bb:cc:dd:ee:ff",
+			})
+			Expect(err).ToNot(BeNil())
+			Expect(err.Error()).To(Equal("ip 10.0.0.10 is outside of the lan subnet 192.168.1.0/24"))
+		})
+
+		It("set wildcard record", func() {
+			mockLuciRPC.EXPECT().Uci(ctx, "get_all", []string{"dhcp"}).
+				Return(`{"guest": {".type": "dnsmasq", ".index": 0}, "u": {".type": "dnsmasq", ".index": 1}, "iot": {".type": "dnsmasq", ".index": 2}}`, nil)
+			mockLuciRPC.EXPECT().Uci(ctx, "get", []string{"dhcp", "u", "address"}).
+				Return(`["/ads.com/"]`, nil)
+			mockLuciRPC.EXPECT().UciList(ctx, "set", []string{"dhcp", "u", "address"},
+				[]string{"/ads.com/", "/apps.foo.com/2.2.2.2"}).Return("", nil)
+
+			config := DefaultConfig()
+			config.Instances = []Instance{{Name: "guest", Domains: []string{"guest.lan"}}}
+			d := dnsmasq{
+				config:  config,
+				lucirpc: mockLuciRPC,
+			}
+			err := d.AddDNSRecord(ctx, DNSRecord{
+				Type: "A",
+				IP:   "2.2.2.2",
+				Name: "*.apps.foo.com",
+			})
+			Expect(err).To(BeNil())
+		})
+
+		It("set CNAME record", func() {
+			cfg := "foobar"
+			cname := "foo.bar.com"
+			target := "bar.foo.com"
+
+			mockLuciRPC.EXPECT().Uci(ctx, "add", []string{"dhcp", "cname"}).Return(cfg, nil)
+			mockLuciRPC.EXPECT().Uci(ctx, "set", []string{"dhcp", cfg, "cname", cname}).Return("", nil)
+			mockLuciRPC.EXPECT().Uci(ctx, "set", []string{"dhcp", cfg, "target", target}).Return("", nil)
+
+			d := dnsmasq{
+				config:  DefaultConfig(),
+				lucirpc: mockLuciRPC,
+			}
+			err := d.AddDNSRecord(ctx, DNSRecord{
+				Type:   "CNAME",
+				CName:  cname,
+				Target: target,
+			})
+			Expect(err).To(BeNil())
+		})
+
+		It("CNAME without cname", func() {
+			d := dnsmasq{config: DefaultConfig()}
+			err := d.AddDNSRecord(ctx, DNSRecord{
+				Type:   "CNAME",
+				Target: "foo.bar.com",
+			})
+			Expect(err).ToNot(BeNil())
+			Expect(err.Error()).To(Equal("cname is required"))
+		})
+
+		It("CNAME without target", func() {
+			d := dnsmasq{config: DefaultConfig()}
+			err := d.AddDNSRecord(ctx, DNSRecord{
+				Type:  "CNAME",
+				CName: "foobar",
+			})
+			Expect(err).ToNot(BeNil())
+			Expect(err.Error()).To(Equal("target is required"))
+		})
+	})
+
+	Context("Update DNS", func() {
+		It("update A record", func() {
+			cfg := "x"
+			updatedIP := "2.2.2.2"
+
+			mockLuciRPC.EXPECT().Uci(ctx, "set", []string{"dhcp", cfg, "ip", updatedIP}).Return("", nil)
+
+			d := dnsmasq{
+				config:  DefaultConfig(),
+				lucirpc: mockLuciRPC,
+			}
+			err := d.UpdateDNSRecord(ctx, DNSRecord{
+				Type:    "A",
+				Name:    "happy.com",
+				IP:      "1.1.1.1",
+				Section: cfg,
+			}, DNSRecord{
+				Type: "A",
+				Name: "happy.com",
+				IP:   updatedIP,
+			})
+			Expect(err).To(BeNil())
+		})
+
+		It("update CNAME record", func() {
+			cfg := "y"
+			updatedTarget := "foo.bar.com"
+
+			mockLuciRPC.EXPECT().Uci(ctx, "set", []string{"dhcp", cfg, "target", updatedTarget}).Return("", nil)
+
+			d := dnsmasq{
+				config:  DefaultConfig(),
+				lucirpc: mockLuciRPC,
+			}
+			err := d.UpdateDNSRecord(ctx, DNSRecord{
+				Type:    "CNAME",
+				CName:   "happy.com",
+				Target:  "bar.foo.com",
+				Section: cfg,
+			}, DNSRecord{
+				Type:   "CNAME",
+				CName:  "happy.com",
+				Target: updatedTarget,
+			})
+			Expect(err).To(BeNil())
+		})
+
+		It("unchanged record", func() {
+			d := dnsmasq{
+				config:  DefaultConfig(),
+				lucirpc: mockLuciRPC,
+			}
+			err := d.UpdateDNSRecord(ctx, DNSRecord{
+				Type:    "A",
+				Name:    "happy.com",
+				IP:      "1.1.1.1",
+				Section: "x",
+			}, DNSRecord{
+				Type: "A",
+				Name: "happy.com",
+				IP:   "1.1.1.1",
+			})
+			Expect(err).To(BeNil())
+		})
+
+		It("update host record", func() {
+			mockLuciRPC.EXPECT().Uci(ctx, "get_all", []string{"network", "lan"}).
+				Return(`{"ipaddr":"192.168.1.1","netmask":"255.255.255.0"}`, nil)
+			mockLuciRPC.EXPECT().Uci(ctx, "set", []string{"dhcp", "w", "ip", "192.168.1.11"}).Return("", nil)
+
+			d := dnsmasq{
+				config:  DefaultConfig(),
+				lucirpc: mockLuciRPC,
+			}
+			err := d.UpdateDNSRecord(ctx, DNSRecord{
+				Type:    "A",
+				Name:    "node",
+				IP:      "192.168.1.10",
+				MAC:     "AA:BB:CC:DD:EE:FF",
+				Section: "w",
+			}, DNSRecord{
+				Type: "A",
+				Name: "node",
+				IP:   "192.168.1.11",
+				MAC:  "aa:bb:cc:dd:ee:ff",
+			})
+			Expect(err).To(BeNil())
+		})
+
+		It("update A record to a host", func() {
+			gomock.InOrder(
+				mockLuciRPC.EXPECT().Uci(ctx, "delete", []string{"dhcp", "x"}).Return("", nil),
+				mockLuciRPC.EXPECT().Uci(ctx, "get_all", []string{"network", "lan"}).
+					Return(`{"ipaddr":"192.168.1.1","netmask":"255.255.255.0"}`, nil),
+				mockLuciRPC.EXPECT().Uci(ctx, "add", []string{"dhcp", "host"}).Return("w", nil),
+				mockLuciRPC.EXPECT().Uci(ctx, "set", []string{"dhcp", "w", "name", "node"}).Return("", nil),
+				mockLuciRPC.EXPECT().Uci(ctx, "set", []string{"dhcp", "w", "mac", "aa:bb:cc:dd:ee:ff"}).Return("", nil),
+				mockLuciRPC.EXPECT().Uci(ctx, "set", []string{"dhcp", "w", "ip", "192.168.1.10"}).Return("", nil),
+				mockLuciRPC.EXPECT().Uci(ctx, "set", []string{"dhcp", "w", "dns", "1"}).Return("", nil),
+			)
+
+			d := dnsmasq{
+				config:  DefaultConfig(),
+				lucirpc: mockLuciRPC,
+			}
+			err := d.UpdateDNSRecord(ctx, DNSRecord{
+				Type:    "A",
+				Name:    "node",
+				IP:      "192.168.1.10",
+				Section: "x",
+			}, DNSRecord{
+				Type: "A",
+				Name: "node",
+				IP:   "192.168.1.10",
+				MAC:  "aa:bb:cc:dd:ee:ff",
+			})
+			Expect(err).To(BeNil())
+		})
+
+		It("set the owner of a wildcard record", func() {
+			mockLuciRPC.EXPECT().Uci(ctx, "get_all", []string{"dhcp"}).Return(`{"u": {".type": "dnsmasq"}}`, nil)
+			mockLuciRPC.EXPECT().Uci(ctx, "get", []string{"dhcp", "u", "address"}).Return("", nil)
+			mockLuciRPC.EXPECT().UciList(ctx, "set", []string{"dhcp", "u", "address"},
+				[]string{"/apps.foo.com/2.2.2.2"}).Return("", nil)
+			mockLuciRPC.EXPECT().Uci(ctx, "get", []string{"dhcp", "u", "address_owner"}).Return("", nil)
+			mockLuciRPC.EXPECT().UciList(ctx, "set", []string{"dhcp", "u", "address_owner"},
+				[]string{"/apps.foo.com/2.2.2.2=external-dns"}).Return("", nil)
+
+			d := dnsmasq{
+				config:  DefaultConfig(),
+				lucirpc: mockLuciRPC,
+			}
+			Expect(d.AddDNSRecord(ctx, DNSRecord{
+				Type:  "A",
+				IP:    "2.2.2.2",
+				Name:  "*.apps.foo.com",
+				Owner: "external-dns",
+			})).To(Succeed())
+		})
+
+		It("move the owner of an updated wildcard record", func() {
+			mockLuciRPC.EXPECT().Uci(ctx, "get", []string{"dhcp", "u", "address"}).
+				Return(`["/apps.foo.com/2.2.2.2"]`, nil)
+			mockLuciRPC.EXPECT().UciList(ctx, "set", []string{"dhcp", "u", "address"},
+				[]string{"/apps.foo.com/3.3.3.3"}).Return("", nil)
+			mockLuciRPC.EXPECT().Uci(ctx, "get", []string{"dhcp", "u", "address_owner"}).
+				Return(`["/ads.com/1.1.1.1=other", "/apps.foo.com/2.2.2.2=external-dns"]`, nil)
+			mockLuciRPC.EXPECT().UciList(ctx, "set", []string{"dhcp", "u", "address_owner"},
+				[]string{"/ads.com/1.1.1.1=other", "/apps.foo.com/3.3.3.3=external-dns"}).Return("", nil)
+
+			d := dnsmasq{
+				config:  DefaultConfig(),
+				lucirpc: mockLuciRPC,
+			}
+			Expect(d.UpdateDNSRecord(ctx, DNSRecord{
+				Type:    "A",
+				Name:    "*.apps.foo.com",
+				IP:      "2.2.2.2",
+				Owner:   "external-dns",
+				Section: "u",
+			}, DNSRecord{
+				Type:  "A",
+				Name:  "*.apps.foo.com",
+				IP:    "3.3.3.3",
+				Owner: "external-dns",
+			})).To(Succeed())
+		})
+
+		It("update wildcard record", func() {
+			mockLuciRPC.EXPECT().Uci(ctx, "get", []string{"dhcp", "u", "address"}).
+				Return(`["/ads.com/","/apps.foo.com/2.2.2.2"]`, nil)
+			mockLuciRPC.EXPECT().UciList(ctx, "set", []string{"dhcp", "u", "address"},
+				[]string{"/ads.com/", "/apps.foo.com/3.3.3.3"}).Return("", nil)
+
+			d := dnsmasq{
+				config:  DefaultConfig(),
+				lucirpc: mockLuciRPC,
+			}
+			err := d.UpdateDNSRecord(ctx, DNSRecord{
+				Type:    "A",
+				Name:    "*.apps.foo.com",
+				IP:      "2.2.2.2",
+				Section: "u",
+			}, DNSRecord{
+				Type: "A",
+				Name: "*.apps.foo.com",
+				IP:   "3.3.3.3",
+			})
+			Expect(err).To(BeNil())
+		})
+	})
+
+	Context("Delete DNS", func() {
+		It("delete A record", func() {
+			cfg := "x"
+
+			mockLuciRPC.EXPECT().Uci(ctx, "delete", []string{"dhcp", cfg}).Return("", nil)
+
+			d := dnsmasq{
+				config:  DefaultConfig(),
+				lucirpc: mockLuciRPC,
+			}
+			err := d.DeleteDNSRecord(ctx, DNSRecord{
+				Type:    "A",
+				Name:    "happy.com",
+				IP:      "2.2.2.2",
+				Section: cfg,
+			})
+			Expect(err).To(BeNil())
+		})
+
+		It("delete the owner of a wildcard record", func() {
+			mockLuciRPC.EXPECT().Uci(ctx, "get", []string{"dhcp", "u", "address"}).
+				Return(`["/apps.foo.com/2.2.2.2"]`, nil)
+			mockLuciRPC.EXPECT().Uci(ctx, "delete", []string{"dhcp", "u", "address"}).Return("", nil)
+			mockLuciRPC.EXPECT().Uci(ctx, "get", []string{"dhcp", "u", "address_owner"}).
+				Return("/apps.foo.com/2.2.2.2=external-dns", nil)
+			mockLuciRPC.EXPECT().Uci(ctx, "delete", []string{"dhcp", "u", "address_owner"}).Return("", nil)
+
+			d := dnsmasq{
+				config:  DefaultConfig(),
+				lucirpc: mockLuciRPC,
+			}
+			Expect(d.DeleteDNSRecord(ctx, DNSRecord{
+				Type:    "A",
+				Name:    "*.apps.foo.com",
+				IP:      "2.2.2.2",
+				Owner:   "external-dns",
+				Section: "u",
+			})).To(Succeed())
+		})
+
+		It("delete wildcard record", func() {
+			mockLuciRPC.EXPECT().Uci(ctx, "get", []string{"dhcp", "u", "address"}).
+				Return(`["/apps.foo.com/2.2.2.2"]`, nil)
+			mockLuciRPC.EXPECT().Uci(ctx, "delete", []string{"dhcp", "u", "address"}).Return("", nil)
+
+			d := dnsmasq{
+				config:  DefaultConfig(),
+				lucirpc: mockLuciRPC,
+			}
+			err := d.DeleteDNSRecord(ctx, DNSRecord{
+				Type:    "A",
+				Name:    "*.apps.foo.com",
+				IP:      "2.2.2.2",
+				Section: "u",
+			})
+			Expect(err).To(BeNil())
+		})
+
+		It("delete CNAME record", func() {
+			cfg := "y"
+
+			mockLuciRPC.EXPECT().Uci(ctx, "delete", []string{"dhcp", cfg}).Return("", nil)
+
+			d := dnsmasq{
+				config:  DefaultConfig(),
+				lucirpc: mockLuciRPC,
+			}
+			err := d.DeleteDNSRecord(ctx, DNSRecord{
+				Type:    "CNAME",
+				CName:   "happy.com",
+				Target:  "foo.bar.com",
+				Section: cfg,
+			})
+			Expect(err).To(BeNil())
+		})
+	})
+
+	Context("Instances", func() {
+		var config *Config
+
+		BeforeEach(func() {
+			config = DefaultConfig()
+			config.Instances = []Instance{
+				{
+					Name:    "guest",
+					Domains: []string{"guest.lan"},
+				},
+			}
+		})
+
+		It("get records of managed instances", func() {
+			expectedJson, err := json.Marshal(map[string]section{
+				"x": {
+					DNSRecord: DNSRecord{Type: "domain", Name: "foo.home.lan", IP: "1.1.1.1"},
+				},
+				"y": {
+					DNSRecord: DNSRecord{Type: "domain", Name: "foo.guest.lan", IP: "2.2.2.2"},
+					Instance:  "guest",
+				},
+				"z": {
+					DNSRecord: DNSRecord{Type: "domain", Name: "foo.iot.lan", IP: "3.3.3.3"},
+					Instance:  "iot",
+				},
+				"guest": {
+					DNSRecord: DNSRecord{Type: "dnsmasq"},
+					Address:   []string{"/apps.guest.lan/4.4.4.4"},
+				},
+			})
+			Expect(err).To(BeNil())
+			mockLuciRPC.EXPECT().Uci(ctx, "get_all", []string{"dhcp"}).Return(string(expectedJson), nil)
+
+			d := dnsmasq{
+				config:  config,
+				lucirpc: mockLuciRPC,
+			}
+			resultDNS, err := d.GetDNSRecords(ctx)
+			Expect(err).To(BeNil())
+			Expect(resultDNS).To(Equal(map[string]DNSRecord{
+				"x":               {Type: "A", Name: "foo.home.lan", IP: "1.1.1.1", Section: "x"},
+				"y":               {Type: "A", Name: "foo.guest.lan", IP: "2.2.2.2", Section: "y"},
+				"guest.address.0": {Type: "A", Name: "*.apps.guest.lan", IP: "4.4.4.4", Section: "guest"},
+			}))
+		})
+
+		It("skip the addresses of unmanaged instances", func() {
+			mockLuciRPC.EXPECT().Uci(ctx, "get_all", []string{"dhcp"}).Return(`{
+				"guest": {".type": "dnsmasq", ".index": 0, "address": "/apps.guest.lan/4.4.4.4"},
+				"lan": {".type": "dnsmasq", ".index": 1, "address": "/apps.home.lan/1.1.1.1"},
+				"iot": {".type": "dnsmasq", ".index": 2, "address": "/apps.iot.lan/5.5.5.5"}
+			}`, nil)
+
+			d := dnsmasq{
+				config:  config,
+				lucirpc: mockLuciRPC,
+			}
+			resultDNS, err := d.GetDNSRecords(ctx)
+			Expect(err).To(BeNil())
+			Expect(resultDNS).To(Equal(map[string]DNSRecord{
+				"guest.address.0": {Type: "A", Name: "*.apps.guest.lan", IP: "4.4.4.4", Section: "guest"},
+				"lan.address.0":   {Type: "A", Name: "*.apps.home.lan", IP: "1.1.1.1", Section: "lan"},
+			}))
+		})
+
+		It("mark records stored in another instance as misplaced", func() {
+			mockLuciRPC.EXPECT().Uci(ctx, "get_all", []string{"dhcp"}).Return(`{
+				"x": {".type": "domain", "name": "foo.guest.lan", "ip": "2.2.2.2"},
+				"y": {".type": "cname", "cname": "foo.home.lan", "target": "bar.home.lan", "instance": "guest"}
+			}`, nil)
+
+			d := dnsmasq{
+				config:  config,
+				lucirpc: mockLuciRPC,
+			}
+			resultDNS, err := d.GetDNSRecords(ctx)
+			Expect(err).To(BeNil())
+			Expect(resultDNS).To(Equal(map[string]DNSRecord{
+				"x": {Type: "A", Name: "foo.guest.lan", IP: "2.2.2.2", Section: "x", Misplaced: true},
+				"y": {Type: "CNAME", CName: "foo.home.lan", Target: "bar.home.lan", Section: "y", Misplaced: true},
+			}))
+			Expect(resultDNS["x"].Equal(DNSRecord{Type: "A", Name: "foo.guest.lan", IP: "2.2.2.2"})).To(BeFalse())
+		})
+
+		It("move misplaced records to their instance", func() {
+			mockLuciRPC.EXPECT().Uci(ctx, "set", []string{"dhcp", "x", "instance", "guest"}).Return("", nil)
+			mockLuciRPC.EXPECT().Uci(ctx, "delete", []string{"dhcp", "y", "instance"}).Return("", nil)
+
+			d := dnsmasq{
+				config:  config,
+				lucirpc: mockLuciRPC,
+			}
+			Expect(d.UpdateDNSRecord(ctx,
+				DNSRecord{Type: "A", Name: "foo.guest.lan", IP: "2.2.2.2", Section: "x", Misplaced: true},
+				DNSRecord{Type: "A", Name: "foo.guest.lan", IP: "2.2.2.2"},
+			)).To(Succeed())
+			Expect(d.UpdateDNSRecord(ctx,
+				DNSRecord{Type: "CNAME", CName: "foo.home.lan", Target: "bar.home.lan", Section: "y", Misplaced: true},
+				DNSRecord{Type: "CNAME", CName: "foo.home.lan", Target: "bar.home.lan"},
+			)).To(Succeed())
+		})
+
+		It("set A record of an instance", func() {
+			mockLuciRPC.EXPECT().Uci(ctx, "add", []string{"dhcp", "domain"}).Return("x", nil)
+			mockLuciRPC.EXPECT().Uci(ctx, "set", []string{"dhcp", "x", "instance", "guest"}).Return("", nil)
+			mockLuciRPC.EXPECT().Uci(ctx, "set", []string{"dhcp", "x", "name", "foo.guest.lan"}).Return("", nil)
+			mockLuciRPC.EXPECT().Uci(ctx, "set", []string{"dhcp", "x", "ip", "2.2.2.2"}).Return("", nil)
+
+			d := dnsmasq{
+				config:  config,
+				lucirpc: mockLuciRPC,
+			}
+			err := d.AddDNSRecord(ctx, DNSRecord{
+				Type: "A",
+				Name: "foo.guest.lan",
+				IP:   "2.2.2.2",
+			})
+			Expect(err).To(BeNil())
+		})
+
+		It("set wildcard record of an instance", func() {
+			mockLuciRPC.EXPECT().Uci(ctx, "get", []string{"dhcp", "guest", "address"}).Return("", nil)
+			mockLuciRPC.EXPECT().UciList(ctx, "set", []string{"dhcp", "guest", "address"},
+				[]string{"/apps.guest.lan/4.4.4.4"}).Return("", nil)
+
+			d := dnsmasq{
+				config:  config,
+				lucirpc: mockLuciRPC,
+			}
+			err := d.AddDNSRecord(ctx, DNSRecord{
+				Type: "A",
+				Name: "*.apps.guest.lan",
+				IP:   "4.4.4.4",
+			})
+			Expect(err).To(BeNil())
+		})
+
+		It("set CNAME record in another package", func() {
+			config.Package = "dhcp_k8s"
+			mockLuciRPC.EXPECT().Uci(ctx, "add", []string{"dhcp_k8s", "cname"}).Return("y", nil)
+			mockLuciRPC.EXPECT().Uci(ctx, "set", []string{"dhcp_k8s", "y", "cname", "foo.home.lan"}).Return("", nil)
+			mockLuciRPC.EXPECT().Uci(ctx, "set", []string{"dhcp_k8s", "y", "target", "bar.home.lan"}).Return("", nil)
+
+			d := dnsmasq{
+				config:  config,
+				lucirpc: mockLuciRPC,
+			}
+			err := d.AddDNSRecord(ctx, DNSRecord{
+				Type:   "CNAME",
+				CName:  "foo.home.lan",
+				Target: "bar.home.lan",
+			})
+			Expect(err).To(BeNil())
+		})
+	})
+
+	Context("Index", func() {
+		It("index records by identity", func() {
+			index := NewIndex(map[string]DNSRecord{
+				"b": {Type: "A", Name: "foo.com", IP: "2.2.2.2", Section: "b"},
+				"a": {Type: "A", Name: "foo.com", IP: "1.1.1.1", Section: "a"},
+				"c": {Type: "CNAME", CName: "foo.com", Target: "bar.com", Section: "c"},
+			})
+			Expect(index).To(HaveLen(2))
+			Expect(index[RecordKey{Type: "A", Name: "foo.com"}].Section).To(Equal("a"))
+			Expect(index[RecordKey{Type: "CNAME", Name: "foo.com"}].Section).To(Equal("c"))
+		})
+	})
+
+	Context("Transaction", func() {
+		It("commit", func() {
+			mockLuciRPC.EXPECT().Uci(ctx, "commit", []string{"dhcp"}).Return("", nil)
+
+			d := dnsmasq{
+				config:  DefaultConfig(),
+				lucirpc: mockLuciRPC,
+			}
+			Expect(d.Commit(ctx)).To(Succeed())
+		})
+
+		It("revert", func() {
+			mockLuciRPC.EXPECT().Uci(ctx, "revert", []string{"dhcp"}).Return("", nil)
+
+			d := dnsmasq{
+				config:  DefaultConfig(),
+				lucirpc: mockLuciRPC,
+			}
+			Expect(d.Revert(ctx)).To(Succeed())
+		})
+	})
+
+	Context("Host records", func() {
+		var (
+			fake *fakeLuciRPC
+			d    *dnsmasq
+		)
+
+		BeforeEach(func() {
+			fake = newFakeLuciRPC(uciConfigs{
+				"dhcp": {
+					"x": {".type": "hostrecord", "name": "a.home.lan", "ip": []any{"192.168.1.10", "2001:db8::1"}},
+					"y": {".type": "hostrecord", "name": []any{"b.home.lan"}, "ip": "192.168.1.11"},
+					"z": {".type": "hostrecord", "name": []any{"c.home.lan", "d.home.lan"}, "ip": "192.168.1.12"},
+					"w": {".type": "domain", "name": "e.home.lan", "ip": "192.168.1.13", "owner": "external-dns",
+						"comment": "nas", "tag": []any{"a", "b"}},
+					"v": {".type": "domain", "name": "f.home.lan", "ip": "192.168.1.14"},
+					"u": {".type": "domain", "name": "g_h.home.lan", "ip": "192.168.1.15", "owner": "external-dns"},
+				},
+			})
+			config := DefaultConfig()
+			config.HostRecord = true
+			d = &dnsmasq{config: config, lucirpc: fake}
+		})
+
+		It("should read a record per ip", func() {
+			records, err := d.GetDNSRecords(ctx)
+			Expect(err).To(BeNil())
+			index := NewIndex(records)
+			Expect(index).To(HaveLen(6))
+			Expect(index[RecordKey{Type: "A", Name: "a.home.lan"}].Section).To(Equal("x"))
+			Expect(index[RecordKey{Type: "AAAA", Name: "a.home.lan"}].Section).To(Equal("x"))
+			Expect(index[RecordKey{Type: "A", Name: "b.home.lan"}].IP).To(Equal("192.168.1.11"))
+			Expect(index[RecordKey{Type: "A", Name: "e.home.lan"}].storedIn()).To(Equal("domain"))
+		})
+
+		It("should add the ip to the section of the name", func() {
+			Expect(d.AddDNSRecord(ctx, DNSRecord{Type: "AAAA", Name: "b.home.lan", IP: "2001:db8::2"})).To(Succeed())
+			Expect(fake.staged["dhcp"]["y"]["ip"]).To(Equal([]any{"192.168.1.11", "2001:db8::2"}))
+		})
+
+		It("should delete the section along with its last ip", func() {
+			records, err := d.GetDNSRecords(ctx)
+			Expect(err).To(BeNil())
+			index := NewIndex(records)
+
+			Expect(d.DeleteDNSRecord(ctx, index[RecordKey{Type: "A", Name: "a.home.lan"}])).To(Succeed())
+			Expect(fake.staged["dhcp"]["x"]["ip"]).To(Equal([]any{"2001:db8::1"}))
+
+			Expect(d.DeleteDNSRecord(ctx, index[RecordKey{Type: "A", Name: "b.home.lan"}])).To(Succeed())
+			Expect(fake.staged["dhcp"]).ToNot(HaveKey("y"))
+		})
+
+		It("should migrate the domain sections of the owner", func() {
+			count, skipped, err := d.migrateHostRecords(ctx, "external-dns")
+			Expect(err).To(BeNil())
+			Expect(count).To(Equal(1))
+			Expect(skipped).To(Equal(1))
+			Expect(fake.committed["dhcp"]).ToNot(HaveKey("w"))
+			Expect(fake.committed["dhcp"]).To(HaveKey("v"))
+			Expect(fake.committed["dhcp"]).To(HaveKey("u"))
+			Expect(fake.sys).To(Equal([]string{"init.reload dnsmasq"}))
+
+			records, err := d.GetDNSRecords(ctx)
+			Expect(err).To(BeNil())
+			migrated := NewIndex(records)[RecordKey{Type: "A", Name: "e.home.lan"}]
+			Expect(migrated.storedIn()).To(Equal("hostrecord"))
+			Expect(migrated.IP).To(Equal("192.168.1.13"))
+			Expect(migrated.Owner).To(Equal("external-dns"))
+			Expect(fake.committed["dhcp"][migrated.Section]).To(HaveKeyWithValue("comment", "nas"))
+			Expect(fake.committed["dhcp"][migrated.Section]).To(HaveKeyWithValue("tag", []any{"a", "b"}))
+
+			count, skipped, err = d.migrateHostRecords(ctx, "external-dns")
+			Expect(err).To(BeNil())
+			Expect(count).To(BeZero())
+			Expect(skipped).To(Equal(1))
+		})
+	})
+})
@@ -0,0 +1,210 @@
+package openwrt
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/renanqts/external-dns-openwrt-webhook/pkg/logger"
+	"github.com/renanqts/external-dns-openwrt-webhook/pkg/lucirpc"
+	"go.uber.org/zap"
+)
+
+// readFile returns the content of a file of the router, empty when it does not exist
+func readFile(ctx context.Context, l lucirpc.LuciRPC, path string) (string, error) {
+	result, err := l.Fs(ctx, "readfile", []string{path})
+	if err != nil {
+		return "", err
+	}
+
+	content, err := base64.StdEncoding.DecodeString(result)
+	if err != nil {
+		return "", fmt.Errorf("invalid %s content: %w", path, err)
+	}
+
+	return string(content), nil
+}
+
+// writeFile replaces the content of a file of the router
+func writeFile(ctx context.Context, l lucirpc.LuciRPC, path, content string) error {
+	_, err := l.Fs(ctx, "writefile", []string{path, base64.StdEncoding.EncodeToString([]byte(content))})
+	return err
+}
+
+// fileHeader is the first line of the files owned by the webhook
+const fileHeader = "# managed by external-dns-openwrt-webhook, do not edit"
+
+// fileEntry is a record of a file owned by the webhook, String returns its line
+type fileEntry interface {
+	fmt.Stringer
+	// is reports whether the entry holds the record, whatever its ttl
+	is(record DNSRecord) bool
+	record(path string) DNSRecord
+}
+
+// recordFile holds the records of a file owned by the webhook, a line per entry.
+// Changes are staged in memory until they are committed, the file is then written
+// as a whole. Backends only parse, format and validate the entries.
+type recordFile[E fileEntry] struct {
+	lucirpc lucirpc.LuciRPC
+	path    string
+	// name prefixes the methods told to observe, e.g. "hosts add"
+	name string
+	// observe is told about the entries staged when it is not nil
+	observe Observer
+	parse   func(content string) []E
+	entry   func(record DNSRecord) E
+
+	mu sync.Mutex
+	// staged holds the entries with uncommitted changes, nil when there are none
+	staged []E
+}
+
+func (f *recordFile[E]) records(ctx context.Context) (map[string]DNSRecord, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	entries, err := f.entries(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make(map[string]DNSRecord, len(entries))
+	for index, entry := range entries {
+		records[fmt.Sprintf("%s:%d", f.path, index)] = entry.record(f.path)
+	}
+
+	logger.Log.Debug("current records", zap.Any("records", records))
+	return records, nil
+}
+
+func (f *recordFile[E]) add(ctx context.Context, record DNSRecord) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	entries, err := f.entries(ctx)
+	if err != nil {
+		return err
+	}
+
+	entry := f.entry(record)
+	f.staged = append(entries, entry)
+	f.stage("add", entry)
+	logger.Log.Debug("added record", zap.Any("record", record))
+
+	return nil
+}
+
+// update replaces the entry keeping its position in the file and its owner
+func (f *recordFile[E]) update(ctx context.Context, current, desired DNSRecord) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	entries, err := f.entries(ctx)
+	if err != nil {
+		return err
+	}
+
+	index := slices.IndexFunc(entries, func(entry E) bool { return entry.is(current) })
+	if index < 0 {
+		return fmt.Errorf("record not found in %s: %s %s %s", f.path, current.Type, current.Key().Name, current.Value())
+	}
+
+	desired.Owner = entries[index].record(f.path).Owner
+	entry := f.entry(desired)
+	if entries[index].String() == entry.String() {
+		return nil
+	}
+
+	entries = slices.Clone(entries)
+	entries[index] = entry
+	f.staged = entries
+	f.stage("update", entry)
+	logger.Log.Debug("updated record", zap.Any("current", current), zap.Any("desired", desired))
+
+	return nil
+}
+
+func (f *recordFile[E]) delete(ctx context.Context, record DNSRecord) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	entries, err := f.entries(ctx)
+	if err != nil {
+		return err
+	}
+
+	f.staged = slices.DeleteFunc(slices.Clone(entries), func(entry E) bool {
+		if !entry.is(record) {
+			return false
+		}
+		f.stage("delete", entry)
+		return true
+	})
+	logger.Log.Debug("deleted record", zap.Any("record", record))
+
+	return nil
+}
+
+// commit writes the staged entries to the file
+func (f *recordFile[E]) commit(ctx context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.staged == nil {
+		return nil
+	}
+
+	if err := writeFile(ctx, f.lucirpc, f.path, f.format(f.staged)); err != nil {
+		return err
+	}
+	f.staged = nil
+	logger.Log.Debug("committed changes", zap.String("file", f.path))
+
+	return nil
+}
+
+// revert discards every change staged since the last commit
+func (f *recordFile[E]) revert() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.staged = nil
+	logger.Log.Info("reverted changes")
+}
+
+// stage tells the observer about an entry staged in memory, e.g. method "hosts add"
+// with params [/etc/external-dns.hosts 192.168.1.10 a.home.lan]
+func (f *recordFile[E]) stage(method string, entry E) {
+	if f.observe != nil {
+		f.observe(f.name+" "+method, []string{f.path, entry.String()})
+	}
+}
+
+// entries returns the staged entries or reads them from the file
+func (f *recordFile[E]) entries(ctx context.Context) ([]E, error) {
+	if f.staged != nil {
+		return f.staged, nil
+	}
+
+	content, err := readFile(ctx, f.lucirpc, f.path)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.parse(content), nil
+}
+
+// format returns the content of the file holding the entries
+func (f *recordFile[E]) format(entries []E) string {
+	var b strings.Builder
+	b.WriteString(fileHeader + "\n")
+	for _, entry := range entries {
+		b.WriteString(entry.String() + "\n")
+	}
+
+	return b.String()
+}
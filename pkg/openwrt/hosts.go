@@ -0,0 +1,201 @@
+package openwrt
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/renanqts/external-dns-openwrt-webhook/pkg/logger"
+	"github.com/renanqts/external-dns-openwrt-webhook/pkg/lucirpc"
+	"go.uber.org/zap"
+)
+
+// hostsEntry is a single name of a hosts file line, the ttl, the owner and the labels
+// are kept in a trailing comment, e.g. "# ttl=600 owner=external-dns label=resource=service/default/foo"
+type hostsEntry struct {
+	IP     string
+	Name   string
+	TTL    string
+	Owner  string
+	Labels map[string]string
+}
+
+func newHostsEntry(record DNSRecord) hostsEntry {
+	return hostsEntry{IP: record.IP, Name: record.Name, TTL: record.TTL, Owner: record.Owner, Labels: record.Labels}
+}
+
+// is reports whether the entry holds the record, whatever its ttl
+func (e hostsEntry) is(record DNSRecord) bool {
+	return e.IP == record.IP && e.Name == record.Name
+}
+
+func (e hostsEntry) record(path string) DNSRecord {
+	return DNSRecord{
+		Type:    addressType(e.IP),
+		IP:      e.IP,
+		Name:    e.Name,
+		TTL:     e.TTL,
+		Owner:   e.Owner,
+		Labels:  e.Labels,
+		Section: path,
+	}
+}
+
+// hosts stores address records in a hosts file owned by the webhook,
+// dnsmasq serves it through its addnhosts option.
+type hosts struct {
+	lucirpc lucirpc.LuciRPC
+	file    *recordFile[hostsEntry]
+}
+
+// newHosts returns the hosts backend, observe is told about the entries staged when it is not nil
+func newHosts(config *Config, l lucirpc.LuciRPC, observe Observer) *hosts {
+	return &hosts{
+		lucirpc: l,
+		file: &recordFile[hostsEntry]{
+			lucirpc: l,
+			path:    config.HostsFile,
+			name:    "hosts",
+			observe: observe,
+			parse:   parseHosts,
+			entry:   newHostsEntry,
+		},
+	}
+}
+
+func (h *hosts) GetDNSRecords(ctx context.Context) (map[string]DNSRecord, error) {
+	return h.file.records(ctx)
+}
+
+func (h *hosts) AddDNSRecord(ctx context.Context, record DNSRecord) error {
+	if err := h.validate(record); err != nil {
+		return err
+	}
+
+	return h.file.add(ctx, record)
+}
+
+// UpdateDNSRecord replaces the entry keeping its position in the file
+func (h *hosts) UpdateDNSRecord(ctx context.Context, current, desired DNSRecord) error {
+	if err := h.validate(desired); err != nil {
+		return err
+	}
+
+	return h.file.update(ctx, current, desired)
+}
+
+func (h *hosts) DeleteDNSRecord(ctx context.Context, record DNSRecord) error {
+	return h.file.delete(ctx, record)
+}
+
+// Commit writes the staged entries to the hosts file
+func (h *hosts) Commit(ctx context.Context) error {
+	return h.file.commit(ctx)
+}
+
+// Revert discards every change staged since the last commit
+func (h *hosts) Revert(_ context.Context) error {
+	h.file.revert()
+	return nil
+}
+
+// Reload makes dnsmasq read the addnhosts files again
+func (h *hosts) Reload(ctx context.Context) error {
+	if _, err := h.lucirpc.Sys(ctx, "init.reload", []string{"dnsmasq"}); err != nil {
+		return err
+	}
+	logger.Log.Debug("reloaded dnsmasq")
+
+	return nil
+}
+
+func (h *hosts) validate(record DNSRecord) error {
+	if err := ValidateRecord(record); err != nil {
+		return err
+	}
+
+	switch record.Type {
+	case "A", "AAAA":
+		if record.MAC != "" {
+			return fmt.Errorf("static leases are not supported by the hosts backend")
+		}
+
+		if isWildcard(record.Name) {
+			return fmt.Errorf("wildcard records are not supported by the hosts backend")
+		}
+
+		for key, value := range record.Labels {
+			if !ValidLabel(key, value) {
+				return fmt.Errorf("invalid label: %s=%s", key, value)
+			}
+		}
+	default:
+		return fmt.Errorf("invalid record type: %s", record.Type)
+	}
+
+	return nil
+}
+
+// parseHosts returns an entry per name, lines with several names are split
+func parseHosts(content string) []hostsEntry {
+	entries := []hostsEntry{}
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line, comment, _ := strings.Cut(scanner.Text(), "#")
+		var ttl, owner string
+		var labels []string
+		for _, field := range strings.Fields(comment) {
+			key, value, _ := strings.Cut(field, "=")
+			switch key {
+			case "ttl":
+				if validateTTL(value) == nil {
+					ttl = value
+				}
+			case "owner":
+				owner = value
+			case labelOption:
+				labels = append(labels, value)
+			}
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		if _, err := netip.ParseAddr(fields[0]); err != nil {
+			logger.Log.Debug("ignoring hosts line", zap.String("line", scanner.Text()))
+			continue
+		}
+
+		for _, name := range fields[1:] {
+			entries = append(entries, hostsEntry{IP: fields[0], Name: name, TTL: ttl, Owner: owner,
+				Labels: parseLabels(labels)})
+		}
+	}
+
+	return entries
+}
+
+// String returns the line of the entry in the hosts file
+func (e hostsEntry) String() string {
+	line := e.IP + " " + e.Name
+
+	var comment []string
+	if e.TTL != "" {
+		comment = append(comment, "ttl="+e.TTL)
+	}
+	if e.Owner != "" {
+		comment = append(comment, "owner="+e.Owner)
+	}
+	for _, label := range formatLabels(e.Labels) {
+		comment = append(comment, labelOption+"="+label)
+	}
+	if len(comment) > 0 {
+		line += " # " + strings.Join(comment, " ")
+	}
+
+	return line
+}
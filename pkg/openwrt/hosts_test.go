@@ -0,0 +1,72 @@
+package openwrt
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Hosts", func() {
+	var (
+		ctx  context.Context
+		fake *fakeLuciRPC
+		h    *hosts
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		fake = newFakeLuciRPC(uciConfigs{})
+		h = newHosts(DefaultConfig(), fake, nil)
+	})
+
+	It("should read a missing file as empty", func() {
+		records, err := h.GetDNSRecords(ctx)
+		Expect(err).To(BeNil())
+		Expect(records).To(BeEmpty())
+	})
+
+	It("should split lines with several names", func() {
+		fake.files[defaultHostsFile] = "192.168.1.10 a.home.lan b.home.lan # comment\nfoo bar\n"
+
+		records, err := h.GetDNSRecords(ctx)
+		Expect(err).To(BeNil())
+		index := NewIndex(records)
+		Expect(index).To(HaveLen(2))
+		Expect(index).To(HaveKey(RecordKey{Type: "A", Name: "a.home.lan"}))
+		Expect(index).To(HaveKey(RecordKey{Type: "A", Name: "b.home.lan"}))
+	})
+
+	It("should write the file on commit only", func() {
+		Expect(h.AddDNSRecord(ctx, DNSRecord{Type: "A", Name: "a.home.lan", IP: "192.168.1.10"})).To(Succeed())
+		Expect(h.AddDNSRecord(ctx, DNSRecord{Type: "AAAA", Name: "a.home.lan", IP: "2001:db8::1"})).To(Succeed())
+		Expect(fake.files).To(BeEmpty())
+
+		Expect(h.Commit(ctx)).To(Succeed())
+		Expect(fake.files).To(HaveKeyWithValue(defaultHostsFile, fileHeader+"\n192.168.1.10 a.home.lan\n2001:db8::1 a.home.lan\n"))
+		Expect(fake.committed).To(BeEmpty())
+	})
+
+	It("should keep the labels in the comment", func() {
+		Expect(h.AddDNSRecord(ctx, DNSRecord{Type: "A", Name: "a.home.lan", IP: "192.168.1.10", Owner: "external-dns",
+			Labels: map[string]string{"resource": "service/default/a", "controller": "dns"}})).To(Succeed())
+		Expect(h.Commit(ctx)).To(Succeed())
+		Expect(fake.files).To(HaveKeyWithValue(defaultHostsFile, fileHeader+
+			"\n192.168.1.10 a.home.lan # owner=external-dns label=controller=dns label=resource=service/default/a\n"))
+	})
+
+	It("should reject records the hosts file cannot hold", func() {
+		Expect(h.AddDNSRecord(ctx, DNSRecord{Type: "CNAME", CName: "b.home.lan", Target: "a.home.lan"})).To(MatchError("invalid record type: CNAME"))
+		Expect(h.AddDNSRecord(ctx, DNSRecord{Type: "A", Name: "a.home.lan", IP: "2001:db8::1"})).To(MatchError("invalid ip: 2001:db8::1, not an IPv4 address"))
+		Expect(h.AddDNSRecord(ctx, DNSRecord{Type: "A", Name: "*.home.lan", IP: "192.168.1.10"})).To(MatchError("wildcard records are not supported by the hosts backend"))
+		Expect(h.AddDNSRecord(ctx, DNSRecord{Type: "A", Name: "a.home.lan", IP: "192.168.1.10", MAC: "aa:bb:cc:dd:ee:ff"})).To(MatchError("static leases are not supported by the hosts backend"))
+		Expect(h.AddDNSRecord(ctx, DNSRecord{Type: "A", Name: "a.home.lan", IP: "192.168.1.10", Labels: map[string]string{"note": "two words"}})).To(MatchError("invalid label: note=two words"))
+		Expect(h.file.staged).To(BeNil())
+	})
+
+	It("should fail to update a record missing from the file", func() {
+		current := DNSRecord{Type: "A", Name: "a.home.lan", IP: "192.168.1.10"}
+		desired := DNSRecord{Type: "A", Name: "a.home.lan", IP: "192.168.1.11"}
+		Expect(h.UpdateDNSRecord(ctx, current, desired)).ToNot(Succeed())
+	})
+})
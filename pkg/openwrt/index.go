@@ -0,0 +1,166 @@
+package openwrt
+
+import (
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// RecordKey identifies a record regardless of where it points to
+type RecordKey struct {
+	Type string
+	Name string
+}
+
+// Key returns the identity of the record
+func (r DNSRecord) Key() RecordKey {
+	if r.Type == "CNAME" {
+		return RecordKey{Type: r.Type, Name: r.CName}
+	}
+
+	return RecordKey{Type: r.Type, Name: r.Name}
+}
+
+// Index maps record identities to the records on the router
+type Index map[RecordKey]DNSRecord
+
+// NewIndex indexes records by identity, when several sections hold
+// the same record the one with the lowest section name is kept
+func NewIndex(records map[string]DNSRecord) Index {
+	keys := make([]string, 0, len(records))
+	for key := range records {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	index := make(Index, len(records))
+	for _, key := range keys {
+		record := records[key]
+		if _, ok := index[record.Key()]; ok {
+			continue
+		}
+		index[record.Key()] = record
+	}
+
+	return index
+}
+
+// RecordSets maps record identities to the records of every target on the router
+type RecordSets map[RecordKey][]DNSRecord
+
+// NewRecordSets groups records by identity with one record per target, sorted by target.
+// When several sections hold the same target the one with the lowest section name is kept.
+func NewRecordSets(records map[string]DNSRecord) RecordSets {
+	sets := make(RecordSets)
+	for _, key := range slices.Sorted(maps.Keys(records)) {
+		record := records[key]
+		if _, ok := sets.Find(record); ok {
+			continue
+		}
+		sets[record.Key()] = append(sets[record.Key()], record)
+	}
+
+	for _, set := range sets {
+		slices.SortFunc(set, func(a, b DNSRecord) int { return strings.Compare(a.Value(), b.Value()) })
+	}
+
+	return sets
+}
+
+// Find returns the record of the set pointing to the same target as record
+func (s RecordSets) Find(record DNSRecord) (DNSRecord, bool) {
+	for _, current := range s[record.Key()] {
+		if current.Value() == record.Value() {
+			return current, true
+		}
+	}
+
+	return DNSRecord{}, false
+}
+
+// Remove drops the record pointing to the same target as record from its set
+func (s RecordSets) Remove(record DNSRecord) {
+	set := slices.DeleteFunc(slices.Clone(s[record.Key()]), func(current DNSRecord) bool {
+		return current.Value() == record.Value()
+	})
+	if len(set) == 0 {
+		delete(s, record.Key())
+		return
+	}
+
+	s[record.Key()] = set
+}
+
+// Value returns where the record points to, its ip or the target of a CNAME
+func (r DNSRecord) Value() string {
+	if r.Type == "CNAME" {
+		return r.Target
+	}
+
+	return r.IP
+}
+
+// Equal reports whether both records are stored the same way on the router, labels
+// included, and a misplaced record never equals one which is not. The owner is left
+// out, it is only stored along with new records.
+func (r DNSRecord) Equal(other DNSRecord) bool {
+	return r.sectionType() == other.sectionType() && maps.Equal(r.options(), other.options()) &&
+		(!r.keepsLabels() || maps.Equal(r.Labels, other.Labels)) && r.Misplaced == other.Misplaced
+}
+
+// sectionType returns the uci section type storing the record
+func (r DNSRecord) sectionType() string {
+	switch {
+	case r.Type == "CNAME":
+		return "cname"
+	case r.MAC != "":
+		return "host"
+	case isWildcard(r.Name):
+		return "dnsmasq"
+	default:
+		return "domain"
+	}
+}
+
+// storedIn returns the type of the section holding a record read from the router
+func (r DNSRecord) storedIn() string {
+	if r.stored != "" {
+		return r.stored
+	}
+
+	return r.sectionType()
+}
+
+// KeepsTTL reports whether the ttl of the record is stored along with it
+func (r DNSRecord) KeepsTTL() bool {
+	return r.sectionType() != "dnsmasq"
+}
+
+// keepsLabels reports whether the labels of the record are stored along with it
+func (r DNSRecord) keepsLabels() bool {
+	return r.sectionType() != "dnsmasq"
+}
+
+// options returns the uci options describing the record in its section,
+// the ttl is left out when it is not set or cannot be stored
+func (r DNSRecord) options() map[string]string {
+	var options map[string]string
+	switch r.sectionType() {
+	case "cname":
+		options = map[string]string{"cname": r.CName, "target": r.Target}
+	case "host":
+		options = map[string]string{"name": r.Name, "ip": r.IP, "mac": normalizeMAC(r.MAC)}
+	case "dnsmasq":
+		// entries of the address list have no ttl
+		return map[string]string{"name": r.Name, "ip": r.IP}
+	default:
+		options = map[string]string{"name": r.Name, "ip": r.IP}
+	}
+
+	if r.TTL != "" {
+		options["ttl"] = r.TTL
+	}
+
+	return options
+}
@@ -0,0 +1,62 @@
+package openwrt
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/renanqts/external-dns-openwrt-webhook/pkg/lucirpc"
+)
+
+// labelOption is the uci list holding the labels of a record
+const labelOption = "label"
+
+// ValidLabel reports whether every backend can store the label,
+// keys cannot contain "=" and neither keys nor values whitespaces or "#"
+func ValidLabel(key, value string) bool {
+	return key != "" && !strings.ContainsAny(key, "= \t\n#") && !strings.ContainsAny(value, " \t\n#")
+}
+
+// parseLabels returns the labels of a label list, which is either a single value
+// or a list. Entries which are not key=value are ignored.
+func parseLabels(value any) map[string]string {
+	var labels map[string]string
+	for _, entry := range toList(value) {
+		key, value, ok := strings.Cut(entry, "=")
+		if !ok || key == "" {
+			continue
+		}
+
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		labels[key] = value
+	}
+
+	return labels
+}
+
+// formatLabels renders the labels as a label list sorted by key
+func formatLabels(labels map[string]string) []string {
+	entries := make([]string, 0, len(labels))
+	for _, key := range slices.Sorted(maps.Keys(labels)) {
+		entries = append(entries, key+"="+labels[key])
+	}
+
+	return entries
+}
+
+// updateLabels changes the label list of the section, it is deleted when desired is empty
+func updateLabels(ctx context.Context, l lucirpc.LuciRPC, config, cfg string, current, desired map[string]string) error {
+	switch {
+	case maps.Equal(current, desired):
+		return nil
+	case len(desired) == 0:
+		_, err := l.Uci(ctx, "delete", []string{config, cfg, labelOption})
+		return err
+	default:
+		_, err := l.UciList(ctx, "set", []string{config, cfg, labelOption}, formatLabels(desired))
+		return err
+	}
+}
@@ -0,0 +1,137 @@
+package openwrt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/renanqts/external-dns-openwrt-webhook/pkg/logger"
+	"github.com/renanqts/external-dns-openwrt-webhook/pkg/lucirpc"
+	"go.uber.org/zap"
+)
+
+// domainOptions are the options of a domain section the webhook reads,
+// the other ones are carried over to the hostrecord section by the migration
+var domainOptions = []string{"name", "ip", "ttl", "owner", labelOption, "instance"}
+
+// MigrateHostRecords converts the domain sections of the owner to hostrecord sections
+// in a single commit, every section is converted when owner is empty. It returns the
+// number of converted records and of invalid records left as they are.
+func MigrateHostRecords(ctx context.Context, cfg *Config, owner string) (int, int, error) {
+	if cfg.Backend != BackendDnsmasq {
+		return 0, 0, fmt.Errorf("host records are not supported by the %s backend", cfg.Backend)
+	}
+
+	lrcp, err := lucirpc.New(cfg.LuciRPC)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	migrated := *cfg
+	migrated.HostRecord = true
+	d := &dnsmasq{
+		config:  &migrated,
+		lucirpc: lrcp,
+	}
+
+	return d.migrateHostRecords(ctx, owner)
+}
+
+func (d *dnsmasq) migrateHostRecords(ctx context.Context, owner string) (int, int, error) {
+	records, err := d.GetDNSRecords(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	count, skipped := 0, 0
+	for _, key := range slices.Sorted(maps.Keys(records)) {
+		record := records[key]
+		if record.storedIn() != "domain" || (owner != "" && record.Owner != owner) {
+			continue
+		}
+
+		if err := d.validate(ctx, record); err != nil {
+			logger.Log.Warn("skipping invalid record", zap.String("cfg", record.Section), zap.String("name", record.Name),
+				zap.Error(err))
+			skipped++
+			continue
+		}
+
+		if err := d.migrateHostRecord(ctx, record); err != nil {
+			d.revert(ctx)
+			return 0, skipped, fmt.Errorf("%s: %w", record.Section, err)
+		}
+		logger.Log.Info("migrated record", zap.String("cfg", record.Section), zap.String("name", record.Name), zap.String("ip", record.IP))
+		count++
+	}
+
+	if count == 0 {
+		return 0, skipped, nil
+	}
+
+	if err := d.Commit(ctx); err != nil {
+		d.revert(ctx)
+		return 0, skipped, err
+	}
+
+	return count, skipped, d.Reload(ctx)
+}
+
+// migrateHostRecord replaces the domain section of the record by its hostrecord section,
+// options added by hand are carried over unless the hostrecord section has them already
+func (d *dnsmasq) migrateHostRecord(ctx context.Context, record DNSRecord) error {
+	result, err := d.lucirpc.Uci(ctx, "get_all", []string{d.config.Package, record.Section})
+	if err != nil {
+		return err
+	}
+
+	var options map[string]any
+	if err := json.Unmarshal([]byte(result), &options); err != nil {
+		return err
+	}
+
+	if err := d.deleteRecord(ctx, record); err != nil {
+		return err
+	}
+
+	cfg, err := d.storeHostRecord(ctx, record)
+	if err != nil {
+		return err
+	}
+
+	for _, option := range slices.Sorted(maps.Keys(options)) {
+		if strings.HasPrefix(option, ".") || slices.Contains(domainOptions, option) {
+			continue
+		}
+
+		current, err := d.lucirpc.Uci(ctx, "get", []string{d.config.Package, cfg, option})
+		if err != nil {
+			return err
+		}
+		if current != "" {
+			logger.Log.Warn("option set already, not carried over", zap.String("cfg", cfg), zap.String("option", option))
+			continue
+		}
+
+		values := toList(options[option])
+		if _, ok := options[option].(string); ok {
+			_, err = d.lucirpc.Uci(ctx, "set", []string{d.config.Package, cfg, option, values[0]})
+		} else {
+			_, err = d.lucirpc.UciList(ctx, "set", []string{d.config.Package, cfg, option}, values)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (d *dnsmasq) revert(ctx context.Context) {
+	if err := d.Revert(ctx); err != nil {
+		logger.Log.Error("failed to revert changes", zap.Error(err))
+	}
+}
@@ -0,0 +1,55 @@
+package openwrt
+
+import (
+	"context"
+
+	"github.com/renanqts/external-dns-openwrt-webhook/pkg/lucirpc"
+)
+
+// Observer is told about every write a backend stages on the router, e.g.
+// method "uci set" with params [dhcp cfg01411c ip 192.168.1.10]
+type Observer func(method string, params []string)
+
+// observed passes the successful writes of a backend to an observer
+type observed struct {
+	lucirpc.LuciRPC
+	observe Observer
+}
+
+func (o *observed) Uci(ctx context.Context, method string, params []string) (string, error) {
+	result, err := o.LuciRPC.Uci(ctx, method, params)
+	if err != nil {
+		return result, err
+	}
+
+	switch method {
+	case "add":
+		// the name of the new section is only known once it is added
+		o.observe("uci "+method, append(params[:len(params):len(params)], result))
+	case "set", "delete":
+		o.observe("uci "+method, params)
+	}
+
+	return result, nil
+}
+
+func (o *observed) UciList(ctx context.Context, method string, params []string, list []string) (string, error) {
+	result, err := o.LuciRPC.UciList(ctx, method, params, list)
+	if err != nil {
+		return result, err
+	}
+
+	o.observe("uci "+method, append(params[:len(params):len(params)], list...))
+	return result, nil
+}
+
+func (o *observed) Fs(ctx context.Context, method string, params []string) (string, error) {
+	result, err := o.LuciRPC.Fs(ctx, method, params)
+	if err != nil || method != "writefile" {
+		return result, err
+	}
+
+	// the content is left out, it is base64 encoded
+	o.observe("fs "+method, params[:1])
+	return result, nil
+}
@@ -0,0 +1,63 @@
+package openwrt
+
+import (
+	"context"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Observer", func() {
+	var (
+		ctx    context.Context
+		fake   *fakeLuciRPC
+		writes []string
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		fake = newFakeLuciRPC(uciConfigs{"dhcp": {"cfg01411c": {".type": "dnsmasq"}}})
+		writes = nil
+	})
+
+	observe := func(method string, params []string) {
+		writes = append(writes, method+" "+strings.Join(params, " "))
+	}
+
+	It("observes the successful writes", func() {
+		d := &dnsmasq{config: DefaultConfig(), lucirpc: &observed{LuciRPC: fake, observe: observe}}
+		Expect(d.AddDNSRecord(ctx, DNSRecord{Type: "A", Name: "a.home.lan", IP: "192.168.1.10", TTL: "600"})).To(Succeed())
+		Expect(d.AddDNSRecord(ctx, DNSRecord{Type: "A", Name: "*.apps.home.lan", IP: "192.168.1.11"})).To(Succeed())
+		Expect(writes).To(Equal([]string{
+			"uci add dhcp domain cfg000001",
+			"uci set dhcp cfg000001 name a.home.lan",
+			"uci set dhcp cfg000001 ip 192.168.1.10",
+			"uci set dhcp cfg000001 ttl 600",
+			"uci set dhcp cfg01411c address /apps.home.lan/192.168.1.11",
+		}))
+	})
+
+	It("observes the entries staged and the files written without their content", func() {
+		h := newHosts(DefaultConfig(), &observed{LuciRPC: fake, observe: observe}, observe)
+		Expect(h.AddDNSRecord(ctx, DNSRecord{Type: "A", Name: "a.home.lan", IP: "192.168.1.10"})).To(Succeed())
+		Expect(writes).To(Equal([]string{"hosts add " + defaultHostsFile + " 192.168.1.10 a.home.lan"}))
+
+		Expect(h.Commit(ctx)).To(Succeed())
+		Expect(writes[1:]).To(Equal([]string{"fs writefile " + defaultHostsFile}))
+	})
+
+	It("observes the entries staged by unbound", func() {
+		u := newUnbound(DefaultConfig(), fake, observe)
+		record := DNSRecord{Type: "A", Name: "a.home.lan", IP: "192.168.1.10"}
+		updated := DNSRecord{Type: "A", Name: "a.home.lan", IP: "192.168.1.10", TTL: "600"}
+		Expect(u.AddDNSRecord(ctx, record)).To(Succeed())
+		Expect(u.UpdateDNSRecord(ctx, record, updated)).To(Succeed())
+		Expect(u.DeleteDNSRecord(ctx, updated)).To(Succeed())
+		Expect(writes).To(Equal([]string{
+			"unbound add " + defaultUnboundFile + ` local-data: "a.home.lan. IN A 192.168.1.10"`,
+			"unbound update " + defaultUnboundFile + ` local-data: "a.home.lan. 600 IN A 192.168.1.10"`,
+			"unbound delete " + defaultUnboundFile + ` local-data: "a.home.lan. 600 IN A 192.168.1.10"`,
+		}))
+	})
+})
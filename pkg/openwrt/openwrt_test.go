@@ -2,14 +2,18 @@ package openwrt
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
+	"maps"
+	"sort"
+	"strings"
 	"testing"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
-	mocks "github.com/renanqts/external-dns-openwrt-webhook/internal/mocks/lucirpc"
 	"github.com/renanqts/external-dns-openwrt-webhook/pkg/logger"
-	"go.uber.org/mock/gomock"
+	"github.com/renanqts/external-dns-openwrt-webhook/pkg/lucirpc"
 )
 
 func TestOpenWRT(t *testing.T) {
@@ -31,378 +35,382 @@ var _ = AfterSuite(func() {
 	_ = logger.Log.Sync()
 })
 
-var _ = Describe("OpenWRT", func() {
-	var (
-		ctx         context.Context
-		mockCtrl    *gomock.Controller
-		mockLuciRPC *mocks.MockLuciRPC
-	)
-
-	BeforeEach(func() {
-		ctx = context.Background()
-		mockCtrl = gomock.NewController(GinkgoT())
-		mockLuciRPC = mocks.NewMockLuciRPC(mockCtrl)
-	})
-
-	AfterEach(func() {
-		mockCtrl.Finish()
-	})
-
-	Context("Get DNS", func() {
-		It("get all records", func() {
-			expectedJson, err := json.Marshal(map[string]DNSRecord{
-				"x": {
-					Type: "domain",
-					Name: "foobar",
-					IP:   "1.1.1.1",
-				},
-				"y": {
-					Type:   "cname",
-					CName:  "foobar",
-					Target: "bar.foo.com",
-				},
-				"z": {
-					Type: "whatever",
-				},
-			})
-			Expect(err).To(BeNil())
-			mockLuciRPC.EXPECT().Uci(ctx, "get_all", []string{"dhcp"}).Return(string(expectedJson), nil)
-			o := openWRT{
-				lucirpc: mockLuciRPC,
-			}
-			resultDNS, err := o.GetDNSRecords(ctx)
-			Expect(err).To(BeNil())
-			Expect(resultDNS).ToNot(BeNil())
-			Expect(resultDNS).To(Equal(map[string]DNSRecord{
-				"x": {
-					Type: "A",
-					Name: "foobar",
-					IP:   "1.1.1.1",
-				},
-				"y": {
-					Type:   "CNAME",
-					CName:  "foobar",
-					Target: "bar.foo.com",
-				},
-			}))
-		})
-	})
+type uciConfigs map[string]map[string]map[string]any
+
+// fakeLuciRPC is an in-memory uci keeping staged and committed configs apart
+// and a file system storing file contents in plain text
+type fakeLuciRPC struct {
+	committed uciConfigs
+	staged    uciConfigs
+	files     map[string]string
+	sections  int
+	writes    int
+	sys       []string
+}
 
-	Context("Set DNS", func() {
-		It("set A record with success", func() {
-			cfg := "foobar"
-			ip := "1.1.1.1"
-			name := "foo.bar.com"
+func newFakeLuciRPC(configs uciConfigs) *fakeLuciRPC {
+	f := &fakeLuciRPC{committed: configs, files: map[string]string{}}
+	for _, sections := range configs {
+		for name, options := range sections {
+			options[".name"] = name
+			options[".index"] = f.sections
+			f.sections++
+		}
+	}
+	f.staged = copyConfigs(configs)
 
-			mockLuciRPC.EXPECT().Uci(ctx, "add", []string{"dhcp", "domain"}).Return(cfg, nil)
-			mockLuciRPC.EXPECT().Uci(ctx, "set", []string{"dhcp", cfg, "name", name}).Return("", nil)
-			mockLuciRPC.EXPECT().Uci(ctx, "set", []string{"dhcp", cfg, "ip", ip}).Return("", nil)
-			mockLuciRPC.EXPECT().Uci(ctx, "commit", []string{"dhcp"}).Return("", nil)
+	return f
+}
 
-			o := openWRT{
-				lucirpc: mockLuciRPC,
-			}
-			err := o.SetDNSRecords(ctx, []DNSRecord{
-				{
-					Type: "A",
-					IP:   ip,
-					Name: name,
-				},
-			})
-			Expect(err).To(BeNil())
-		})
+func (f *fakeLuciRPC) Uci(_ context.Context, method string, params []string) (string, error) {
+	switch method {
+	case "get_all":
+		if len(params) == 2 {
+			b, err := json.Marshal(f.section(params[0], params[1]))
+			return string(b), err
+		}
+		b, err := json.Marshal(f.staged[params[0]])
+		return string(b), err
+	case "get":
+		value, ok := f.section(params[0], params[1])[params[2]]
+		if !ok {
+			return "", nil
+		}
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+		b, err := json.Marshal(value)
+		return string(b), err
+	case "add":
+		f.writes++
+		name := fmt.Sprintf("cfg%06x", f.sections)
+		if f.staged[params[0]] == nil {
+			f.staged[params[0]] = map[string]map[string]any{}
+		}
+		f.staged[params[0]][name] = map[string]any{".type": params[1], ".name": name, ".index": f.sections}
+		f.sections++
+		return name, nil
+	case "set":
+		f.writes++
+		f.section(params[0], params[1])[params[2]] = params[3]
+		return "", nil
+	case "delete":
+		f.writes++
+		if len(params) == 3 {
+			delete(f.section(params[0], params[1]), params[2])
+			return "", nil
+		}
+		delete(f.staged[params[0]], f.section(params[0], params[1])[".name"].(string))
+		return "", nil
+	case "commit":
+		f.committed[params[0]] = copyConfigs(f.staged)[params[0]]
+		return "", nil
+	case "revert":
+		f.staged[params[0]] = copyConfigs(f.committed)[params[0]]
+		return "", nil
+	}
 
-		It("A without name", func() {
-			o := openWRT{}
-			err := o.SetDNSRecords(ctx, []DNSRecord{
-				{
-					Type: "A",
-					IP:   "1.1.1.1",
-				},
-			})
-			Expect(err).ToNot(BeNil())
-			Expect(err.Error()).To(Equal("name is required"))
-		})
+	return "", fmt.Errorf("unsupported method: %s", method)
+}
 
-		It("A without ip", func() {
-			o := openWRT{}
-			err := o.SetDNSRecords(ctx, []DNSRecord{
-				{
-					Type: "A",
-					Name: "foobar",
-				},
-			})
-			Expect(err).ToNot(BeNil())
-			Expect(err.Error()).To(Equal("ip is required"))
-		})
+func (f *fakeLuciRPC) UciList(_ context.Context, method string, params []string, list []string) (string, error) {
+	if method != "set" {
+		return "", fmt.Errorf("unsupported method: %s", method)
+	}
 
-		It("set CNAME record", func() {
-			cfg := "foobar"
-			cname := "foo.bar.com"
-			target := "bar.foo.com"
+	f.writes++
+	values := make([]any, 0, len(list))
+	for _, value := range list {
+		values = append(values, value)
+	}
+	f.section(params[0], params[1])[params[2]] = values
 
-			mockLuciRPC.EXPECT().Uci(ctx, "add", []string{"dhcp", "cname"}).Return(cfg, nil)
-			mockLuciRPC.EXPECT().Uci(ctx, "set", []string{"dhcp", cfg, "cname", cname}).Return("", nil)
-			mockLuciRPC.EXPECT().Uci(ctx, "set", []string{"dhcp", cfg, "target", target}).Return("", nil)
-			mockLuciRPC.EXPECT().Uci(ctx, "commit", []string{"dhcp"}).Return("", nil)
+	return "", nil
+}
+
+func (f *fakeLuciRPC) Sys(_ context.Context, method string, params []string) (string, error) {
+	f.sys = append(f.sys, method+" "+strings.Join(params, " "))
+	return "", nil
+}
+
+func (f *fakeLuciRPC) Fs(_ context.Context, method string, params []string) (string, error) {
+	switch method {
+	case "readfile":
+		content, ok := f.files[params[0]]
+		if !ok {
+			return "", nil
+		}
+		return base64.StdEncoding.EncodeToString([]byte(content)), nil
+	case "writefile":
+		f.writes++
+		content, err := base64.StdEncoding.DecodeString(params[1])
+		if err != nil {
+			return "", err
+		}
+		f.files[params[0]] = string(content)
+		return "", nil
+	}
+
+	return "", fmt.Errorf("unsupported method: %s", method)
+}
+
+// section resolves names as well as the extended syntax @type[index]
+func (f *fakeLuciRPC) section(config, name string) map[string]any {
+	sections := f.staged[config]
+	if !strings.HasPrefix(name, "@") {
+		return sections[name]
+	}
+
+	var sectionType string
+	var index int
+	if _, err := fmt.Sscanf(strings.NewReplacer("[", " ", "]", "").Replace(name[1:]), "%s %d", &sectionType, &index); err != nil {
+		return nil
+	}
+
+	var matches []map[string]any
+	for _, options := range sections {
+		if options[".type"] == sectionType {
+			matches = append(matches, options)
+		}
+	}
+	sort.Slice(matches, func(i, j int) bool { return matches[i][".index"].(int) < matches[j][".index"].(int) })
+
+	if index >= len(matches) {
+		return nil
+	}
+	return matches[index]
+}
+
+func copyConfigs(configs uciConfigs) uciConfigs {
+	result := make(uciConfigs, len(configs))
+	for config, sections := range configs {
+		result[config] = make(map[string]map[string]any, len(sections))
+		for name, options := range sections {
+			result[config][name] = maps.Clone(options)
+		}
+	}
 
-			o := openWRT{
-				lucirpc: mockLuciRPC,
+	return result
+}
+
+// backends run the conformance specs every backend has to pass,
+// other is a record of another type supported by the backend
+var backends = []struct {
+	name    string
+	service string
+	configs func() uciConfigs
+	files   map[string]string
+	other   DNSRecord
+	new     func(lucirpc.LuciRPC) OpenWRT
+}{
+	{
+		name:    BackendDnsmasq,
+		service: "dnsmasq",
+		configs: func() uciConfigs {
+			return uciConfigs{
+				"dhcp": {
+					"cfg01411c": {".type": "dnsmasq", "domainneeded": "1"},
+					"lan":       {".type": "dhcp", "interface": "lan"},
+				},
 			}
-			err := o.SetDNSRecords(ctx, []DNSRecord{
-				{
-					Type:   "CNAME",
-					CName:  cname,
-					Target: target,
+		},
+		other: DNSRecord{Type: "CNAME", CName: "b.home.lan", Target: "a.home.lan"},
+		new: func(l lucirpc.LuciRPC) OpenWRT {
+			return &dnsmasq{config: DefaultConfig(), lucirpc: l}
+		},
+	},
+	{
+		name:    BackendDnsmasq + " hostrecord",
+		service: "dnsmasq",
+		configs: func() uciConfigs {
+			return uciConfigs{
+				"dhcp": {
+					"cfg01411c": {".type": "dnsmasq", "domainneeded": "1"},
+					"cfg02c6ad": {".type": "hostrecord", "name": []any{"c.home.lan", "d.home.lan"}, "ip": "192.168.1.12"},
 				},
+			}
+		},
+		other: DNSRecord{Type: "AAAA", Name: "a.home.lan", IP: "2001:db8::1"},
+		new: func(l lucirpc.LuciRPC) OpenWRT {
+			config := DefaultConfig()
+			config.HostRecord = true
+			return &dnsmasq{config: config, lucirpc: l}
+		},
+	},
+	{
+		name:    BackendUnbound,
+		service: "unbound",
+		configs: func() uciConfigs { return uciConfigs{} },
+		files: map[string]string{
+			defaultUnboundFile: fileHeader + "\n\n# comment\nlocal-zone: \"home.lan.\" transparent\n",
+		},
+		other: DNSRecord{Type: "CNAME", CName: "b.home.lan", Target: "a.home.lan"},
+		new: func(l lucirpc.LuciRPC) OpenWRT {
+			return newUnbound(DefaultConfig(), l, nil)
+		},
+	},
+	{
+		name:    BackendHosts,
+		service: "dnsmasq",
+		configs: func() uciConfigs { return uciConfigs{} },
+		files: map[string]string{
+			defaultHostsFile: fileHeader + "\n\n# comment\nfoo\n",
+		},
+		other: DNSRecord{Type: "AAAA", Name: "b.home.lan", IP: "2001:db8::1"},
+		new: func(l lucirpc.LuciRPC) OpenWRT {
+			return newHosts(DefaultConfig(), l, nil)
+		},
+	},
+}
+
+var _ = Describe("Conformance", func() {
+	for _, backend := range backends {
+		Context(backend.name, func() {
+			var (
+				ctx  context.Context
+				fake *fakeLuciRPC
+				o    OpenWRT
+
+				a     = DNSRecord{Type: "A", Name: "a.home.lan", IP: "192.168.1.10"}
+				other = backend.other
+			)
+
+			BeforeEach(func() {
+				ctx = context.Background()
+				fake = newFakeLuciRPC(backend.configs())
+				maps.Copy(fake.files, backend.files)
+				o = backend.new(fake)
 			})
-			Expect(err).To(BeNil())
-		})
 
-		It("CNAME without cname", func() {
-			o := openWRT{}
-			err := o.SetDNSRecords(ctx, []DNSRecord{
-				{
-					Type:   "CNAME",
-					Target: "foo.bar.com",
-				},
+			records := func() map[RecordKey]DNSRecord {
+				current, err := o.GetDNSRecords(ctx)
+				Expect(err).To(BeNil())
+				index := NewIndex(current)
+				Expect(index).To(HaveLen(len(current)))
+				return index
+			}
+
+			It("ignores other sections", func() {
+				Expect(records()).To(BeEmpty())
 			})
-			Expect(err).ToNot(BeNil())
-			Expect(err.Error()).To(Equal("cname is required"))
-		})
 
-		It("CNAME without target", func() {
-			o := openWRT{}
-			err := o.SetDNSRecords(ctx, []DNSRecord{
-				{
-					Type:  "CNAME",
-					CName: "foobar",
-				},
+			It("adds records", func() {
+				Expect(o.AddDNSRecord(ctx, a)).To(Succeed())
+				Expect(o.AddDNSRecord(ctx, other)).To(Succeed())
+
+				current := records()
+				Expect(current).To(HaveLen(2))
+				Expect(current[a.Key()].Equal(a)).To(BeTrue())
+				Expect(current[a.Key()].Section).ToNot(BeEmpty())
+				Expect(current[other.Key()].Equal(other)).To(BeTrue())
 			})
-			Expect(err).ToNot(BeNil())
-			Expect(err.Error()).To(Equal("target is required"))
-		})
-	})
-
-	Context("Update DNS", func() {
-		It("update A record", func() {
-			cfg := "x"
-			dnsName := "happy.com"
-			updatedIP := "2.2.2.2"
-
-			expectedCurrentDNSRecords := map[string]DNSRecord{
-				cfg: {
-					Type: "domain",
-					Name: dnsName,
-					IP:   "1.1.1.1",
-				},
-				"y": {
-					Type:   "cname",
-					CName:  "foo.bar.com",
-					Target: "bar.foo.com",
-				},
-			}
 
-			expectedCurrentJson, err := json.Marshal(expectedCurrentDNSRecords)
-			Expect(err).To(BeNil())
-			mockLuciRPC.EXPECT().Uci(ctx, "get_all", []string{"dhcp"}).Return(string(expectedCurrentJson), nil)
-			mockLuciRPC.EXPECT().Uci(ctx, "delete", []string{"dhcp", cfg}).Return("", nil)
-			mockLuciRPC.EXPECT().Uci(ctx, "add", []string{"dhcp", "domain"}).Return(cfg, nil)
-			mockLuciRPC.EXPECT().Uci(ctx, "set", []string{"dhcp", cfg, "name", dnsName}).Return("", nil)
-			mockLuciRPC.EXPECT().Uci(ctx, "set", []string{"dhcp", cfg, "ip", updatedIP}).Return("", nil)
-			mockLuciRPC.EXPECT().Uci(ctx, "commit", []string{"dhcp"}).Return("", nil)
-
-			o := openWRT{
-				lucirpc: mockLuciRPC,
-			}
-			err = o.UpdateDNSRecords(ctx, []DNSRecord{
-				{
-					Type: "A",
-					Name: dnsName,
-					IP:   updatedIP,
-				},
+			It("rejects invalid records", func() {
+				Expect(o.AddDNSRecord(ctx, DNSRecord{Type: "A", Name: "a.home.lan"})).ToNot(Succeed())
+				Expect(o.AddDNSRecord(ctx, DNSRecord{Type: "CNAME", CName: "b.home.lan"})).ToNot(Succeed())
+				Expect(o.AddDNSRecord(ctx, DNSRecord{Type: "MX", Name: "a.home.lan"})).ToNot(Succeed())
 			})
-			Expect(err).To(BeNil())
-		})
 
-		It("update CNAME record", func() {
-			cfg := "y"
-			cname := "happy.com"
-			updatedTarget := "foo.bar.com"
+			It("updates records in place", func() {
+				Expect(o.AddDNSRecord(ctx, a)).To(Succeed())
+				current := records()[a.Key()]
 
-			expectedCurrentDNSRecords := map[string]DNSRecord{
-				"x": {
-					Type: "domain",
-					Name: "happy.com",
-					IP:   "1.1.1.1",
-				},
-				cfg: {
-					Type:   "cname",
-					CName:  cname,
-					Target: "bar.foo.com",
-				},
-			}
+				desired := a
+				desired.IP = "192.168.1.11"
+				Expect(o.UpdateDNSRecord(ctx, current, desired)).To(Succeed())
 
-			expectedCurrentJson, err := json.Marshal(expectedCurrentDNSRecords)
-			Expect(err).To(BeNil())
-			mockLuciRPC.EXPECT().Uci(ctx, "get_all", []string{"dhcp"}).Return(string(expectedCurrentJson), nil)
-			mockLuciRPC.EXPECT().Uci(ctx, "delete", []string{"dhcp", cfg}).Return("", nil)
-			mockLuciRPC.EXPECT().Uci(ctx, "add", []string{"dhcp", "cname"}).Return(cfg, nil)
-			mockLuciRPC.EXPECT().Uci(ctx, "set", []string{"dhcp", cfg, "cname", cname}).Return("", nil)
-			mockLuciRPC.EXPECT().Uci(ctx, "set", []string{"dhcp", cfg, "target", updatedTarget}).Return("", nil)
-			mockLuciRPC.EXPECT().Uci(ctx, "commit", []string{"dhcp"}).Return("", nil)
-
-			o := openWRT{
-				lucirpc: mockLuciRPC,
-			}
-			err = o.UpdateDNSRecords(ctx, []DNSRecord{
-				{
-					Type:   "CNAME",
-					CName:  cname,
-					Target: updatedTarget,
-				},
+				updated := records()[a.Key()]
+				Expect(updated.Equal(desired)).To(BeTrue())
+				Expect(updated.Section).To(Equal(current.Section))
 			})
-			Expect(err).To(BeNil())
-		})
 
-		It("not found", func() {
-			expectedCurrentDNSRecords := map[string]DNSRecord{
-				"x": {
-					Type: "A",
-					Name: "happy.com",
-					IP:   "1.1.1.1",
-				},
-				"y": {
-					Type:   "CNAME",
-					CName:  "foo.bar.com",
-					Target: "bar.foo.com",
-				},
-			}
+			It("keeps the ttl of records", func() {
+				withTTL := a
+				withTTL.TTL = "600"
+				Expect(o.AddDNSRecord(ctx, withTTL)).To(Succeed())
+				current := records()[a.Key()]
+				Expect(current.TTL).To(Equal("600"))
 
-			expectedCurrentJson, err := json.Marshal(expectedCurrentDNSRecords)
-			Expect(err).To(BeNil())
-			mockLuciRPC.EXPECT().Uci(ctx, "get_all", []string{"dhcp"}).Return(string(expectedCurrentJson), nil)
+				desired := withTTL
+				desired.TTL = "60"
+				Expect(o.UpdateDNSRecord(ctx, current, desired)).To(Succeed())
+				current = records()[a.Key()]
+				Expect(current.TTL).To(Equal("60"))
 
-			o := openWRT{
-				lucirpc: mockLuciRPC,
-			}
-			err = o.UpdateDNSRecords(ctx, []DNSRecord{
-				{
-					Type:   "CNAME",
-					CName:  "whatever",
-					Target: "3.3.3.3",
-				},
+				Expect(o.UpdateDNSRecord(ctx, current, a)).To(Succeed())
+				Expect(records()[a.Key()].TTL).To(BeEmpty())
+
+				withTTL.TTL = "soon"
+				Expect(o.UpdateDNSRecord(ctx, records()[a.Key()], withTTL)).ToNot(Succeed())
 			})
-			Expect(err).ToNot(BeNil())
-			Expect(err.Error()).To(Equal("records not found: [{CNAME   whatever 3.3.3.3}]"))
-		})
-	})
-
-	Context("Delete DNS", func() {
-		It("delete A record", func() {
-			cfg := "x"
-			name := "happy.com"
-			ip := "2.2.2.2"
-
-			expectedCurrentDNSRecords := map[string]DNSRecord{
-				cfg: {
-					Type: "domain",
-					Name: name,
-					IP:   ip,
-				},
-				"y": {
-					Type:   "cname",
-					CName:  "foo.bar.com",
-					Target: "bar.foo.com",
-				},
-			}
 
-			expectedCurrentJson, err := json.Marshal(expectedCurrentDNSRecords)
-			Expect(err).To(BeNil())
-			mockLuciRPC.EXPECT().Uci(ctx, "get_all", []string{"dhcp"}).Return(string(expectedCurrentJson), nil)
-			mockLuciRPC.EXPECT().Uci(ctx, "delete", []string{"dhcp", cfg}).Return("", nil)
-			mockLuciRPC.EXPECT().Uci(ctx, "commit", []string{"dhcp"}).Return("", nil)
+			It("keeps the owner of records", func() {
+				owned := a
+				owned.Owner = "external-dns"
+				Expect(o.AddDNSRecord(ctx, owned)).To(Succeed())
+				current := records()[a.Key()]
+				Expect(current.Owner).To(Equal("external-dns"))
+
+				desired := a
+				desired.IP = "192.168.1.11"
+				Expect(o.UpdateDNSRecord(ctx, current, desired)).To(Succeed())
+				Expect(records()[a.Key()].Owner).To(Equal("external-dns"))
+			})
 
-			o := openWRT{
-				lucirpc: mockLuciRPC,
-			}
-			err = o.DeleteDNSRecords(ctx, []DNSRecord{
-				{
-					Type: "A",
-					Name: name,
-					IP:   ip,
-				},
+			It("keeps the labels of records", func() {
+				labeled := a
+				labeled.Labels = map[string]string{"resource": "ingress/default/a"}
+				Expect(o.AddDNSRecord(ctx, labeled)).To(Succeed())
+				current := records()[a.Key()]
+				Expect(current.Labels).To(Equal(labeled.Labels))
+
+				desired := labeled
+				desired.Labels = map[string]string{"resource": "ingress/default/b", "controller": "dns"}
+				Expect(o.UpdateDNSRecord(ctx, current, desired)).To(Succeed())
+				current = records()[a.Key()]
+				Expect(current.Labels).To(Equal(desired.Labels))
+
+				Expect(o.UpdateDNSRecord(ctx, current, a)).To(Succeed())
+				Expect(records()[a.Key()].Labels).To(BeEmpty())
 			})
-			Expect(err).To(BeNil())
-		})
 
-		It("delete CNAME record", func() {
-			cfg := "y"
-			cname := "happy.com"
-			target := "foo.bar.com"
+			It("does not write unchanged records", func() {
+				Expect(o.AddDNSRecord(ctx, other)).To(Succeed())
+				Expect(o.Commit(ctx)).To(Succeed())
+				current := records()[other.Key()]
 
-			expectedCurrentDNSRecords := map[string]DNSRecord{
-				"x": {
-					Type: "domain",
-					Name: "happy.com",
-					IP:   "1.1.1.1",
-				},
-				cfg: {
-					Type:   "cname",
-					CName:  cname,
-					Target: target,
-				},
-			}
+				writes := fake.writes
+				Expect(o.UpdateDNSRecord(ctx, current, other)).To(Succeed())
+				Expect(o.Commit(ctx)).To(Succeed())
+				Expect(fake.writes).To(Equal(writes))
+			})
 
-			expectedCurrentJson, err := json.Marshal(expectedCurrentDNSRecords)
-			Expect(err).To(BeNil())
-			mockLuciRPC.EXPECT().Uci(ctx, "get_all", []string{"dhcp"}).Return(string(expectedCurrentJson), nil)
-			mockLuciRPC.EXPECT().Uci(ctx, "delete", []string{"dhcp", cfg}).Return("", nil)
-			mockLuciRPC.EXPECT().Uci(ctx, "commit", []string{"dhcp"}).Return("", nil)
+			It("deletes records", func() {
+				Expect(o.AddDNSRecord(ctx, a)).To(Succeed())
+				Expect(o.AddDNSRecord(ctx, other)).To(Succeed())
+				Expect(o.DeleteDNSRecord(ctx, records()[a.Key()])).To(Succeed())
 
-			o := openWRT{
-				lucirpc: mockLuciRPC,
-			}
-			err = o.DeleteDNSRecords(ctx, []DNSRecord{
-				{
-					Type:   "CNAME",
-					CName:  cname,
-					Target: target,
-				},
+				current := records()
+				Expect(current).To(HaveLen(1))
+				Expect(current).To(HaveKey(other.Key()))
 			})
-			Expect(err).To(BeNil())
-		})
 
-		It("not found", func() {
-			expectedCurrentDNSRecords := map[string]DNSRecord{
-				"x": {
-					Type: "domain",
-					Name: "happy.com",
-					IP:   "1.1.1.1",
-				},
-				"y": {
-					Type:   "cname",
-					CName:  "foo.bar.com",
-					Target: "bar.foo.com",
-				},
-			}
+			It("commits and reverts staged changes", func() {
+				Expect(o.AddDNSRecord(ctx, a)).To(Succeed())
+				Expect(o.Commit(ctx)).To(Succeed())
 
-			expectedCurrentJson, err := json.Marshal(expectedCurrentDNSRecords)
-			Expect(err).To(BeNil())
-			mockLuciRPC.EXPECT().Uci(ctx, "get_all", []string{"dhcp"}).Return(string(expectedCurrentJson), nil)
+				Expect(o.AddDNSRecord(ctx, other)).To(Succeed())
+				Expect(o.Revert(ctx)).To(Succeed())
 
-			o := openWRT{
-				lucirpc: mockLuciRPC,
-			}
-			err = o.DeleteDNSRecords(ctx, []DNSRecord{
-				{
-					Type:   "CNAME",
-					CName:  "whatever",
-					Target: "3.3.3.3",
-				},
+				current := records()
+				Expect(current).To(HaveLen(1))
+				Expect(current).To(HaveKey(a.Key()))
+			})
+
+			It("reloads the dns server", func() {
+				Expect(o.Reload(ctx)).To(Succeed())
+				Expect(fake.sys).To(Equal([]string{"init.reload " + backend.service}))
 			})
-			Expect(err).ToNot(BeNil())
-			Expect(err.Error()).To(Equal("records not found: [{CNAME   whatever 3.3.3.3}]"))
 		})
-	})
+	}
 })
@@ -2,9 +2,50 @@ package openwrt
 
 // DNSRecord represents a DNS record in LuciRPC
 type DNSRecord struct {
-	Type   string `json:".type" validate:"required"`
+	Type   string `json:".type"`
 	IP     string `json:"ip,omitempty"`
 	Name   string `json:"name,omitempty"`
 	CName  string `json:"cname,omitempty"`
 	Target string `json:"target,omitempty"`
+	MAC    string `json:"mac,omitempty"`
+	// TTL in seconds, the DNS server default applies when it is empty
+	TTL string `json:"ttl,omitempty"`
+	// Owner identifies the webhook which added the record, it is kept on updates
+	Owner string `json:"owner,omitempty"`
+	// Labels of the endpoint, stored in the label list as key=value entries
+	Labels map[string]string `json:"-"`
+	// Section is the uci section holding the record
+	Section string `json:"-"`
+	// Misplaced is set on records stored in another dnsmasq instance than the one serving their name
+	Misplaced bool `json:"-"`
+	// stored is the type of the section holding the record when it
+	// cannot be told from the record itself, e.g. hostrecord
+	stored string
+}
+
+// section represents a section of the dhcp config as returned by uci get_all,
+// address is either a single value or a list
+type section struct {
+	DNSRecord
+	Address      any    `json:"address,omitempty"`
+	AddressOwner any    `json:"address_owner,omitempty"`
+	Instance     string `json:"instance,omitempty"`
+	Label        any    `json:"label,omitempty"`
+}
+
+// hostRecord represents a hostrecord section of the dhcp config,
+// name and ip are either a single value or a list
+type hostRecord struct {
+	Name     any    `json:"name"`
+	IP       any    `json:"ip"`
+	TTL      string `json:"ttl,omitempty"`
+	Owner    string `json:"owner,omitempty"`
+	Label    any    `json:"label,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// lanInterface represents the addressing of an interface in the network config
+type lanInterface struct {
+	IPAddr  any    `json:"ipaddr"`
+	Netmask string `json:"netmask,omitempty"`
 }
@@ -0,0 +1,256 @@
+package openwrt
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/renanqts/external-dns-openwrt-webhook/pkg/logger"
+	"github.com/renanqts/external-dns-openwrt-webhook/pkg/lucirpc"
+	"go.uber.org/zap"
+)
+
+const localDataKeyword = "local-data:"
+
+// localData is a local-data entry of the unbound file, the owner and the labels are kept
+// in a trailing comment, e.g. `local-data: "a.home.lan. 600 IN A 192.168.1.10" # owner=external-dns`
+type localData struct {
+	Type   string
+	Name   string
+	Value  string
+	TTL    string
+	Owner  string
+	Labels map[string]string
+}
+
+func newLocalData(record DNSRecord) localData {
+	return localData{Type: record.Type, Name: record.Key().Name, Value: localDataValue(record), TTL: record.TTL,
+		Owner: record.Owner, Labels: record.Labels}
+}
+
+// is reports whether the entry holds the record, whatever its ttl
+func (e localData) is(record DNSRecord) bool {
+	return e.Type == record.Type && e.Name == record.Key().Name && e.Value == localDataValue(record)
+}
+
+func (e localData) record(section string) DNSRecord {
+	record := DNSRecord{Type: e.Type, TTL: e.TTL, Owner: e.Owner, Labels: e.Labels, Section: section}
+	if e.Type == "CNAME" {
+		record.CName, record.Target = e.Name, e.Value
+	} else {
+		record.Name, record.IP = e.Name, e.Value
+	}
+
+	return record
+}
+
+// unbound stores records as local-data entries of a file owned by the webhook,
+// unbound_srv.conf includes it in the server clause of unbound.
+type unbound struct {
+	lucirpc lucirpc.LuciRPC
+	file    *recordFile[localData]
+}
+
+// newUnbound returns the unbound backend, observe is told about the entries staged when it is not nil
+func newUnbound(config *Config, l lucirpc.LuciRPC, observe Observer) *unbound {
+	return &unbound{
+		lucirpc: l,
+		file: &recordFile[localData]{
+			lucirpc: l,
+			path:    config.UnboundFile,
+			name:    "unbound",
+			observe: observe,
+			parse:   parseLocalData,
+			entry:   newLocalData,
+		},
+	}
+}
+
+func (u *unbound) GetDNSRecords(ctx context.Context) (map[string]DNSRecord, error) {
+	return u.file.records(ctx)
+}
+
+func (u *unbound) AddDNSRecord(ctx context.Context, record DNSRecord) error {
+	if err := u.validate(record); err != nil {
+		return err
+	}
+
+	return u.file.add(ctx, record)
+}
+
+// UpdateDNSRecord replaces the entry keeping its position in the file
+func (u *unbound) UpdateDNSRecord(ctx context.Context, current, desired DNSRecord) error {
+	if err := u.validate(desired); err != nil {
+		return err
+	}
+
+	return u.file.update(ctx, current, desired)
+}
+
+func (u *unbound) DeleteDNSRecord(ctx context.Context, record DNSRecord) error {
+	return u.file.delete(ctx, record)
+}
+
+// Commit writes the staged entries to the unbound file
+func (u *unbound) Commit(ctx context.Context) error {
+	return u.file.commit(ctx)
+}
+
+// Revert discards every change staged since the last commit
+func (u *unbound) Revert(_ context.Context) error {
+	u.file.revert()
+	return nil
+}
+
+// Reload makes unbound read its config again
+func (u *unbound) Reload(ctx context.Context) error {
+	if _, err := u.lucirpc.Sys(ctx, "init.reload", []string{"unbound"}); err != nil {
+		return err
+	}
+	logger.Log.Debug("reloaded unbound")
+
+	return nil
+}
+
+func (u *unbound) validate(record DNSRecord) error {
+	if err := ValidateRecord(record); err != nil {
+		return err
+	}
+
+	switch record.Type {
+	case "A", "AAAA":
+		if record.MAC != "" {
+			return fmt.Errorf("static leases are not supported by the unbound backend")
+		}
+
+		if isWildcard(record.Name) {
+			return fmt.Errorf("wildcard records are not supported by the unbound backend")
+		}
+	case "CNAME":
+	default:
+		return fmt.Errorf("invalid record type: %s", record.Type)
+	}
+
+	for key, value := range record.Labels {
+		if !ValidLabel(key, value) {
+			return fmt.Errorf("invalid label: %s=%s", key, value)
+		}
+	}
+
+	return nil
+}
+
+func localDataValue(record DNSRecord) string {
+	if record.Type == "CNAME" {
+		return record.Target
+	}
+
+	return record.IP
+}
+
+// parseLocalData returns the A, AAAA and CNAME local-data entries, e.g.
+// `local-data: "a.home.lan. 600 IN A 192.168.1.10"`, other lines are ignored
+func parseLocalData(content string) []localData {
+	entries := []localData{}
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		data, ok := strings.CutPrefix(line, localDataKeyword)
+		if !ok {
+			continue
+		}
+
+		// the resource record is quoted, the comment follows it
+		_, quoted, _ := strings.Cut(data, `"`)
+		rr, comment, ok := strings.Cut(quoted, `"`)
+		if !ok {
+			logger.Log.Debug("ignoring local data", zap.String("line", line))
+			continue
+		}
+
+		entry, ok := parseResourceRecord(rr)
+		if !ok {
+			logger.Log.Debug("ignoring local data", zap.String("line", line))
+			continue
+		}
+
+		_, comment, _ = strings.Cut(comment, "#")
+		var labels []string
+		for _, field := range strings.Fields(comment) {
+			key, value, _ := strings.Cut(field, "=")
+			switch key {
+			case "owner":
+				entry.Owner = value
+			case labelOption:
+				labels = append(labels, value)
+			}
+		}
+		entry.Labels = parseLabels(labels)
+
+		entries = append(entries, entry)
+	}
+
+	return entries
+}
+
+// parseResourceRecord parses "name [ttl] [IN] type value", it returns false
+// for other types than A, AAAA and CNAME
+func parseResourceRecord(rr string) (localData, bool) {
+	fields := strings.Fields(rr)
+	if len(fields) < 3 {
+		return localData{}, false
+	}
+
+	entry := localData{Name: strings.TrimSuffix(fields[0], ".")}
+	fields = fields[1:]
+	if _, err := strconv.ParseUint(fields[0], 10, 32); err == nil {
+		entry.TTL, fields = fields[0], fields[1:]
+	}
+	if len(fields) > 0 && strings.EqualFold(fields[0], "IN") {
+		fields = fields[1:]
+	}
+	if len(fields) != 2 {
+		return localData{}, false
+	}
+
+	entry.Type = strings.ToUpper(fields[0])
+	switch entry.Type {
+	case "A", "AAAA":
+		entry.Value = fields[1]
+	case "CNAME":
+		entry.Value = strings.TrimSuffix(fields[1], ".")
+	default:
+		return localData{}, false
+	}
+
+	return entry, true
+}
+
+// String returns the line of the entry in the unbound file
+func (e localData) String() string {
+	rr := []string{e.Name + "."}
+	if e.TTL != "" {
+		rr = append(rr, e.TTL)
+	}
+	value := e.Value
+	if e.Type == "CNAME" {
+		value += "."
+	}
+	rr = append(rr, "IN", e.Type, value)
+	line := localDataKeyword + ` "` + strings.Join(rr, " ") + `"`
+
+	var comment []string
+	if e.Owner != "" {
+		comment = append(comment, "owner="+e.Owner)
+	}
+	for _, label := range formatLabels(e.Labels) {
+		comment = append(comment, labelOption+"="+label)
+	}
+	if len(comment) > 0 {
+		line += " # " + strings.Join(comment, " ")
+	}
+
+	return line
+}
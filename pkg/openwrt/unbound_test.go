@@ -0,0 +1,76 @@
+package openwrt
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Unbound", func() {
+	var (
+		ctx  context.Context
+		fake *fakeLuciRPC
+		u    *unbound
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		fake = newFakeLuciRPC(uciConfigs{})
+		fake.files[defaultUnboundFile] = `local-data: "foo.home.lan. IN MX 10 mail.home.lan."` + "\n" +
+			`local-data: "a.home.lan. 600 IN A 192.168.1.10" # owner=external-dns label=resource=service/default/a` + "\n" +
+			`  local-data: "b.home.lan CNAME a.home.lan."` + "\n" +
+			`local-data-ptr: "192.168.1.10 a.home.lan"` + "\n"
+		u = newUnbound(DefaultConfig(), fake, nil)
+	})
+
+	It("should read the local data entries of the file", func() {
+		records, err := u.GetDNSRecords(ctx)
+		Expect(err).To(BeNil())
+		Expect(records).To(Equal(map[string]DNSRecord{
+			defaultUnboundFile + ":0": {Type: "A", Name: "a.home.lan", IP: "192.168.1.10", TTL: "600", Owner: "external-dns",
+				Labels: map[string]string{"resource": "service/default/a"}, Section: defaultUnboundFile},
+			defaultUnboundFile + ":1": {Type: "CNAME", CName: "b.home.lan", Target: "a.home.lan", Section: defaultUnboundFile},
+		}))
+	})
+
+	It("should write local data entries on commit only", func() {
+		fake.files = map[string]string{}
+		Expect(u.AddDNSRecord(ctx, DNSRecord{Type: "A", Name: "a.home.lan", IP: "192.168.1.10", TTL: "600", Owner: "external-dns"})).To(Succeed())
+		Expect(u.AddDNSRecord(ctx, DNSRecord{Type: "CNAME", CName: "b.home.lan", Target: "a.home.lan",
+			Labels: map[string]string{"resource": "ingress/default/b"}})).To(Succeed())
+		Expect(fake.files).To(BeEmpty())
+
+		Expect(u.Commit(ctx)).To(Succeed())
+		Expect(fake.files).To(HaveKeyWithValue(defaultUnboundFile, fileHeader+"\n"+
+			`local-data: "a.home.lan. 600 IN A 192.168.1.10" # owner=external-dns`+"\n"+
+			`local-data: "b.home.lan. IN CNAME a.home.lan." # label=resource=ingress/default/b`+"\n"))
+		Expect(fake.committed).To(BeEmpty())
+	})
+
+	It("should reject wildcard records", func() {
+		err := u.AddDNSRecord(ctx, DNSRecord{Type: "A", Name: "*.home.lan", IP: "192.168.1.10"})
+		Expect(err).To(MatchError("wildcard records are not supported by the unbound backend"))
+		Expect(u.file.staged).To(BeNil())
+	})
+
+	It("should reject static leases", func() {
+		err := u.AddDNSRecord(ctx, DNSRecord{Type: "A", Name: "a.home.lan", IP: "192.168.1.10", MAC: "aa:bb:cc:dd:ee:ff"})
+		Expect(err).To(MatchError("static leases are not supported by the unbound backend"))
+		Expect(u.file.staged).To(BeNil())
+	})
+
+	It("should replace records changing type in place", func() {
+		records, err := u.GetDNSRecords(ctx)
+		Expect(err).To(BeNil())
+
+		current := NewIndex(records)[RecordKey{Type: "A", Name: "a.home.lan"}]
+		desired := DNSRecord{Type: "CNAME", CName: "a.home.lan", Target: "c.home.lan"}
+		Expect(u.UpdateDNSRecord(ctx, current, desired)).To(Succeed())
+
+		records, err = u.GetDNSRecords(ctx)
+		Expect(err).To(BeNil())
+		Expect(records).To(HaveLen(2))
+		Expect(records[defaultUnboundFile+":0"].Key()).To(Equal(desired.Key()))
+	})
+})
@@ -0,0 +1,232 @@
+package openwrt
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+const (
+	maxNameLength  = 253
+	maxLabelLength = 63
+)
+
+// FieldError is a problem with a field of a record, Record is set when
+// the record is validated along with others
+type FieldError struct {
+	Record RecordKey
+	Field  string
+	Value  string
+	Reason string
+}
+
+func (e FieldError) Error() string {
+	var message string
+	switch {
+	case e.Value == "":
+		message = e.Field + " " + e.Reason
+	case e.Reason == "":
+		message = fmt.Sprintf("invalid %s: %s", e.Field, e.Value)
+	default:
+		message = fmt.Sprintf("invalid %s: %s, %s", e.Field, e.Value, e.Reason)
+	}
+
+	if e.Record != (RecordKey{}) {
+		return fmt.Sprintf("%s %s: %s", e.Record.Type, e.Record.Name, message)
+	}
+
+	return message
+}
+
+// ValidationError holds every problem found in the records
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		messages = append(messages, err.Error())
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+// ValidateRecord checks the record on its own, it returns a *ValidationError
+// with every problem found
+func ValidateRecord(record DNSRecord) error {
+	if errs := recordErrors(record); len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+
+	return nil
+}
+
+// Validate checks the records before they are written, current holds the records
+// of the router once they are. CNAME chains are followed through current, so a
+// record pointing back to itself is rejected. It returns a *ValidationError with
+// every problem found.
+func Validate(records []DNSRecord, current Index) error {
+	var errs []FieldError
+	for _, record := range records {
+		recordErrs := recordErrors(record)
+		if len(recordErrs) == 0 {
+			recordErrs = loopErrors(record, current)
+		}
+
+		for _, err := range recordErrs {
+			err.Record = record.Key()
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+
+	return nil
+}
+
+// ValidateCNAMEs only follows the CNAME chains of records checked with Validate
+// already through current, the records of the router once they are written
+func ValidateCNAMEs(records []DNSRecord, current Index) error {
+	var errs []FieldError
+	for _, record := range records {
+		for _, err := range loopErrors(record, current) {
+			err.Record = record.Key()
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+
+	return nil
+}
+
+// loopErrors returns an error when the record is a CNAME pointing back to itself through current
+func loopErrors(record DNSRecord, current Index) []FieldError {
+	if record.Type != "CNAME" {
+		return nil
+	}
+
+	if loop := cnameLoop(record, current); loop != nil {
+		return []FieldError{{Field: "target", Value: record.Target, Reason: "cname loop " + strings.Join(loop, " -> ")}}
+	}
+
+	return nil
+}
+
+func recordErrors(record DNSRecord) []FieldError {
+	var errs []FieldError
+	switch record.Type {
+	case "A", "AAAA":
+		if record.Name == "" {
+			errs = append(errs, FieldError{Field: "name", Reason: "is required"})
+		} else if reason := hostnameError(strings.TrimPrefix(record.Name, wildcardPrefix)); reason != "" {
+			errs = append(errs, FieldError{Field: "name", Value: record.Name, Reason: reason})
+		}
+
+		if record.IP == "" {
+			errs = append(errs, FieldError{Field: "ip", Reason: "is required"})
+		} else if reason := ipError(record.Type, record.IP); reason != "" {
+			errs = append(errs, FieldError{Field: "ip", Value: record.IP, Reason: reason})
+		}
+
+		if record.MAC != "" {
+			if mac, err := net.ParseMAC(record.MAC); err != nil || len(mac) != 6 {
+				errs = append(errs, FieldError{Field: "mac", Value: record.MAC})
+			}
+		}
+	case "CNAME":
+		if record.CName == "" {
+			errs = append(errs, FieldError{Field: "cname", Reason: "is required"})
+		} else if reason := hostnameError(record.CName); reason != "" {
+			errs = append(errs, FieldError{Field: "cname", Value: record.CName, Reason: reason})
+		}
+
+		switch {
+		case record.Target == "":
+			errs = append(errs, FieldError{Field: "target", Reason: "is required"})
+		case strings.EqualFold(record.Target, record.CName):
+			errs = append(errs, FieldError{Field: "target", Value: record.Target, Reason: "points to itself"})
+		default:
+			if reason := hostnameError(record.Target); reason != "" {
+				errs = append(errs, FieldError{Field: "target", Value: record.Target, Reason: reason})
+			}
+		}
+	default:
+		errs = append(errs, FieldError{Field: "record type", Value: record.Type})
+	}
+
+	if err := validateTTL(record.TTL); err != nil {
+		errs = append(errs, FieldError{Field: "ttl", Value: record.TTL})
+	}
+
+	return errs
+}
+
+// hostnameError returns why name is not a RFC 1123 hostname, or an empty string
+func hostnameError(name string) string {
+	if len(name) > maxNameLength {
+		return fmt.Sprintf("longer than %d characters", maxNameLength)
+	}
+
+	for _, label := range strings.Split(name, ".") {
+		switch {
+		case label == "":
+			return "empty label"
+		case len(label) > maxLabelLength:
+			return fmt.Sprintf("label longer than %d characters", maxLabelLength)
+		case label[0] == '-' || label[len(label)-1] == '-':
+			return fmt.Sprintf("label %s starts or ends with a hyphen", label)
+		}
+
+		for _, c := range label {
+			if (c < 'a' || c > 'z') && (c < 'A' || c > 'Z') && (c < '0' || c > '9') && c != '-' {
+				return fmt.Sprintf("invalid character %q", c)
+			}
+		}
+	}
+
+	return ""
+}
+
+// ipError returns why ip is not an address of the record type, or an empty string
+func ipError(recordType, ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	switch {
+	case err != nil:
+		return "not an ip address"
+	case addr.Zone() != "":
+		return "zoned addresses are not supported"
+	case recordType == "A" && !addr.Is4():
+		return "not an IPv4 address"
+	case recordType == "AAAA" && (!addr.Is6() || addr.Is4In6()):
+		return "not an IPv6 address"
+	}
+
+	return ""
+}
+
+// cnameLoop returns the names of the chain of the record when it leads back to one of them
+func cnameLoop(record DNSRecord, current Index) []string {
+	chain := []string{record.CName}
+	seen := map[string]bool{strings.ToLower(record.CName): true}
+	target := record.Target
+	for {
+		chain = append(chain, target)
+		if seen[strings.ToLower(target)] {
+			return chain
+		}
+		seen[strings.ToLower(target)] = true
+
+		next, ok := current[RecordKey{Type: "CNAME", Name: target}]
+		if !ok {
+			return nil
+		}
+		target = next.Target
+	}
+}
@@ -0,0 +1,100 @@
+package openwrt
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Validate", func() {
+	It("accepts valid records", func() {
+		for _, record := range []DNSRecord{
+			{Type: "A", Name: "a.home.lan", IP: "192.168.1.10", TTL: "600"},
+			{Type: "A", Name: "*.apps.home.lan", IP: "192.168.1.10"},
+			{Type: "A", Name: "node-1", IP: "192.168.1.10", MAC: "aa:bb:cc:dd:ee:ff"},
+			{Type: "AAAA", Name: "xn--bcher-kva.home.lan", IP: "2001:db8::1"},
+			{Type: "CNAME", CName: "b.home.lan", Target: "a.home.lan"},
+		} {
+			Expect(ValidateRecord(record)).To(Succeed())
+		}
+	})
+
+	It("rejects names which are not hostnames", func() {
+		for name, reason := range map[string]string{
+			"a b.home.lan":                     `invalid character ' '`,
+			"a..home.lan":                      "empty label",
+			"-a.home.lan":                      "label -a starts or ends with a hyphen",
+			strings.Repeat("a", 64) + ".lan":   "label longer than 63 characters",
+			strings.Repeat("a.", 127) + "lan":  "longer than 253 characters",
+			"bücher.home.lan":                  `invalid character 'ü'`,
+			"a.home.lan.":                      "empty label",
+			"a_b.home.lan":                     `invalid character '_'`,
+			"*.*.home.lan":                     `invalid character '*'`,
+			"a.home.lan\nserver=/evil/1.1.1.1": `invalid character '\n'`,
+		} {
+			err := ValidateRecord(DNSRecord{Type: "A", Name: name, IP: "192.168.1.10"})
+			Expect(err).To(MatchError(ContainSubstring(reason)), name)
+		}
+	})
+
+	It("parses ips by record type", func() {
+		Expect(ValidateRecord(DNSRecord{Type: "A", Name: "a.home.lan", IP: "2001:db8::1"})).
+			To(MatchError("invalid ip: 2001:db8::1, not an IPv4 address"))
+		Expect(ValidateRecord(DNSRecord{Type: "AAAA", Name: "a.home.lan", IP: "::ffff:192.168.1.10"})).
+			To(MatchError("invalid ip: ::ffff:192.168.1.10, not an IPv6 address"))
+		Expect(ValidateRecord(DNSRecord{Type: "AAAA", Name: "a.home.lan", IP: "fe80::1%eth0"})).
+			To(MatchError("invalid ip: fe80::1%eth0, zoned addresses are not supported"))
+		Expect(ValidateRecord(DNSRecord{Type: "A", Name: "a.home.lan", IP: "192.168.1.300"})).
+			To(MatchError("invalid ip: 192.168.1.300, not an ip address"))
+	})
+
+	It("returns every problem of the record", func() {
+		err := ValidateRecord(DNSRecord{Type: "CNAME", CName: "a b", TTL: "soon"})
+
+		var validationErr *ValidationError
+		Expect(errors.As(err, &validationErr)).To(BeTrue())
+		Expect(validationErr.Errors).To(Equal([]FieldError{
+			{Field: "cname", Value: "a b", Reason: `invalid character ' '`},
+			{Field: "target", Reason: "is required"},
+			{Field: "ttl", Value: "soon"},
+		}))
+		Expect(err).To(MatchError(`invalid cname: a b, invalid character ' '; target is required; invalid ttl: soon`))
+	})
+
+	It("rejects cnames pointing to themselves", func() {
+		Expect(ValidateRecord(DNSRecord{Type: "CNAME", CName: "a.home.lan", Target: "A.home.lan"})).
+			To(MatchError("invalid target: A.home.lan, points to itself"))
+	})
+
+	It("rejects cname loops through the records of the router", func() {
+		current := NewIndex(map[string]DNSRecord{
+			"x": {Type: "CNAME", CName: "b.home.lan", Target: "c.home.lan"},
+			"y": {Type: "CNAME", CName: "c.home.lan", Target: "a.home.lan"},
+			"z": {Type: "A", Name: "d.home.lan", IP: "192.168.1.10"},
+		})
+
+		Expect(Validate([]DNSRecord{
+			{Type: "CNAME", CName: "a.home.lan", Target: "b.home.lan"},
+			{Type: "CNAME", CName: "e.home.lan", Target: "d.home.lan"},
+			{Type: "A", Name: "f.home.lan"},
+		}, current)).To(MatchError("CNAME a.home.lan: invalid target: b.home.lan, cname loop a.home.lan -> b.home.lan -> c.home.lan -> a.home.lan; " +
+			"A f.home.lan: ip is required"))
+	})
+
+	It("validates records before any write", func() {
+		fake := newFakeLuciRPC(uciConfigs{"dhcp": {}, "unbound": {}})
+		for _, o := range []OpenWRT{
+			&dnsmasq{config: DefaultConfig(), lucirpc: fake},
+			newUnbound(DefaultConfig(), fake, nil),
+			newHosts(DefaultConfig(), fake, nil),
+		} {
+			Expect(o.AddDNSRecord(context.Background(), DNSRecord{Type: "A", Name: "a b", IP: "192.168.1.10"})).ToNot(Succeed())
+			Expect(o.UpdateDNSRecord(context.Background(), DNSRecord{Type: "A", Name: "a.home.lan", IP: "192.168.1.10", Section: "x"},
+				DNSRecord{Type: "A", Name: "a.home.lan", IP: "192.168.1"})).ToNot(Succeed())
+		}
+		Expect(fake.writes).To(BeZero())
+	})
+})